@@ -0,0 +1,177 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAdaptiveInterval      = 5 * time.Second
+	defaultAdaptiveMaxOperations = 1000
+)
+
+// AdaptiveSampler adjusts the sampling rate periodically to sample about
+// targetPerSecond new traces per second, optionally per operation name.
+// The decision is made by the hash of trace id, and the decision of upstream is honored.
+type AdaptiveSampler struct {
+	targetPerSecond float64
+	minRate         float64
+	maxRate         float64
+	interval        time.Duration
+	perOperation    bool
+	maxOperations   int
+
+	global *adaptiveState
+	// operation name -> *adaptiveState
+	operations sync.Map
+	// number of states in operations
+	operationNum int32
+}
+
+// AdaptiveSamplerOption allows for functional options to adjust behaviour
+// of an AdaptiveSampler to be created by NewAdaptiveSampler
+type AdaptiveSamplerOption func(s *AdaptiveSampler)
+
+// WithAdaptiveRateBounds setup the min and max sampling rate, which are 0 and 1 as default
+func WithAdaptiveRateBounds(minRate float64, maxRate float64) AdaptiveSamplerOption {
+	return func(s *AdaptiveSampler) {
+		s.minRate = math.Max(0, math.Min(minRate, 1))
+		s.maxRate = math.Max(s.minRate, math.Min(maxRate, 1))
+	}
+}
+
+// WithAdaptiveInterval setup the interval of adjusting sampling rate
+func WithAdaptiveInterval(interval time.Duration) AdaptiveSamplerOption {
+	return func(s *AdaptiveSampler) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithAdaptivePerOperation setup the target throughput for each operation name.
+// The operations beyond maxOperations share the global sampling rate.
+func WithAdaptivePerOperation(maxOperations int) AdaptiveSamplerOption {
+	return func(s *AdaptiveSampler) {
+		s.perOperation = true
+		if maxOperations > 0 {
+			s.maxOperations = maxOperations
+		}
+	}
+}
+
+// NewAdaptiveSampler creates an AdaptiveSampler, the initial sampling rate is the max rate.
+func NewAdaptiveSampler(targetPerSecond float64, opts ...AdaptiveSamplerOption) *AdaptiveSampler {
+	s := &AdaptiveSampler{
+		targetPerSecond: targetPerSecond,
+		maxRate:         1,
+		interval:        defaultAdaptiveInterval,
+		maxOperations:   defaultAdaptiveMaxOperations,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.global = s.newState()
+	return s
+}
+
+// IsSampled implements IsSampled() of Sampler.
+func (s *AdaptiveSampler) IsSampled(params SamplingParameters) bool {
+	if params.ParentContext != nil {
+		return true
+	}
+	state := s.state(params.OperationName)
+	state.observe(s)
+	return traceIDHash(params.TraceID) < rateThreshold(state.rate())
+}
+
+// Rate returns the current sampling rate of the operation, which is the global
+// sampling rate when the sampler is not per operation.
+func (s *AdaptiveSampler) Rate(operation string) float64 {
+	if s.perOperation {
+		if state, ok := s.operations.Load(operation); ok {
+			return state.(*adaptiveState).rate()
+		}
+	}
+	return s.global.rate()
+}
+
+func (s *AdaptiveSampler) state(operation string) *adaptiveState {
+	if !s.perOperation {
+		return s.global
+	}
+	if state, ok := s.operations.Load(operation); ok {
+		return state.(*adaptiveState)
+	}
+	if int(atomic.AddInt32(&s.operationNum, 1)) > s.maxOperations {
+		atomic.AddInt32(&s.operationNum, -1)
+		return s.global
+	}
+	state, loaded := s.operations.LoadOrStore(operation, s.newState())
+	if loaded {
+		atomic.AddInt32(&s.operationNum, -1)
+	}
+	return state.(*adaptiveState)
+}
+
+func (s *AdaptiveSampler) newState() *adaptiveState {
+	return &adaptiveState{
+		rateBits:    math.Float64bits(s.maxRate),
+		windowStart: time.Now().UnixNano(),
+	}
+}
+
+type adaptiveState struct {
+	rateBits uint64
+	// new traces in the current window
+	count int64
+	// unix nanoseconds of the current window
+	windowStart int64
+	mu          sync.Mutex
+}
+
+func (st *adaptiveState) rate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&st.rateBits))
+}
+
+// observe counts the new trace, and adjusts the sampling rate when the window expires
+func (st *adaptiveState) observe(s *AdaptiveSampler) {
+	atomic.AddInt64(&st.count, 1)
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&st.windowStart) < int64(s.interval) {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	start := atomic.LoadInt64(&st.windowStart)
+	if now-start < int64(s.interval) {
+		return
+	}
+	count := atomic.SwapInt64(&st.count, 0)
+	atomic.StoreInt64(&st.windowStart, now)
+	perSecond := float64(count) / time.Duration(now-start).Seconds()
+	rate := s.maxRate
+	if perSecond > 0 {
+		rate = math.Max(s.minRate, math.Min(s.targetPerSecond/perSecond, s.maxRate))
+	}
+	atomic.StoreUint64(&st.rateBits, math.Float64bits(rate))
+}
@@ -0,0 +1,78 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky/propagation"
+)
+
+func TestAdaptiveSampler_IsSampled(t *testing.T) {
+	sampler := NewAdaptiveSampler(10, WithAdaptiveRateBounds(0.01, 0.8))
+	if sampler.Rate("op") != 0.8 {
+		t.Errorf("initial rate should be the max rate, actual %v", sampler.Rate("op"))
+	}
+	for i := 0; i < 1000; i++ {
+		sampler.IsSampled(SamplingParameters{OperationName: "op", TraceID: fmt.Sprint(i)})
+	}
+	// just for test case, 1000 traces in the last second
+	sampler.global.windowStart = time.Now().Add(-defaultAdaptiveInterval).UnixNano()
+	sampler.global.count = 5000
+	sampler.IsSampled(SamplingParameters{OperationName: "op", TraceID: "trace"})
+	if rate := sampler.Rate("op"); rate < 0.009 || rate > 0.011 {
+		t.Errorf("rate should be adjusted to about 0.01, actual %v", rate)
+	}
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if sampler.IsSampled(SamplingParameters{OperationName: "op", TraceID: fmt.Sprint("trace-", i)}) {
+			sampled++
+		}
+	}
+	if sampled < 50 || sampled > 150 {
+		t.Errorf("sampled %d of 10000 traces with rate 0.01", sampled)
+	}
+	if !sampler.IsSampled(SamplingParameters{ParentContext: &propagation.SpanContext{Sample: 1}}) {
+		t.Error("adaptive sampler should honor the decision of upstream")
+	}
+}
+
+func TestAdaptiveSampler_PerOperation(t *testing.T) {
+	sampler := NewAdaptiveSampler(1, WithAdaptivePerOperation(1), WithAdaptiveInterval(time.Second))
+	sampler.IsSampled(SamplingParameters{OperationName: "busy", TraceID: "1"})
+	sampler.IsSampled(SamplingParameters{OperationName: "other", TraceID: "2"})
+	state, ok := sampler.operations.Load("busy")
+	if !ok {
+		t.Fatal("state of operation is not created")
+	}
+	if _, ok := sampler.operations.Load("other"); ok {
+		t.Error("operations beyond the max should share the global state")
+	}
+	busy := state.(*adaptiveState)
+	busy.windowStart = time.Now().Add(-time.Second).UnixNano()
+	busy.count = 99
+	sampler.IsSampled(SamplingParameters{OperationName: "busy", TraceID: "3"})
+	if rate := sampler.Rate("busy"); rate < 0.009 || rate > 0.011 {
+		t.Errorf("rate of busy operation should be adjusted to about 0.01, actual %v", rate)
+	}
+	if sampler.Rate("other") != 1 {
+		t.Errorf("global rate should not be adjusted, actual %v", sampler.Rate("other"))
+	}
+}
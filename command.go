@@ -0,0 +1,88 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+// The names of the commands of OAP server
+const (
+	CommandConfigurationDiscovery = "ConfigurationDiscoveryCommand"
+	CommandProfileTask            = "ProfileTaskQuery"
+)
+
+// Command is a management command of OAP server, the known commands are
+// decoded as ConfigurationDiscoveryCommand or ProfileTaskCommand, and others as RawCommand.
+type Command interface {
+	CommandName() string
+}
+
+// CommandHandler handles the commands delivered by reporter.
+// It is invoked in the reporter goroutine, and should not block.
+type CommandHandler func(cmd Command)
+
+// CommandDispatcher is a Reporter which delivers the commands of OAP server
+// to the handlers registered by name. Tracer registers its handlers when it is created.
+// The gRPC reporter delivers the commands returned by the management service only,
+// it does not fetch the configuration discovery or profile task commands.
+type CommandDispatcher interface {
+	RegisterCommandHandler(name string, handler CommandHandler)
+}
+
+// ConfigurationDiscoveryCommand carries the dynamic configuration of the service,
+// the keys absent from Config are reset to the default values.
+type ConfigurationDiscoveryCommand struct {
+	SerialNumber string
+	// UUID changes only when the configuration changes
+	UUID   string
+	Config map[string]string
+}
+
+// CommandName implements CommandName() of Command.
+func (*ConfigurationDiscoveryCommand) CommandName() string {
+	return CommandConfigurationDiscovery
+}
+
+// ProfileTaskCommand notifies a new task to profile the endpoint.
+type ProfileTaskCommand struct {
+	SerialNumber string
+	TaskID       string
+	EndpointName string
+	// Duration of the task in minutes
+	Duration int
+	// MinDurationThreshold of the profiled requests in milliseconds
+	MinDurationThreshold int
+	// DumpPeriod of thread stacks in milliseconds
+	DumpPeriod       int
+	MaxSamplingCount int
+	StartTime        int64
+	CreateTime       int64
+}
+
+// CommandName implements CommandName() of Command.
+func (*ProfileTaskCommand) CommandName() string {
+	return CommandProfileTask
+}
+
+// RawCommand is a command unknown to go2sky.
+type RawCommand struct {
+	Name string
+	Args map[string]string
+}
+
+// CommandName implements CommandName() of Command.
+func (c *RawCommand) CommandName() string {
+	return c.Name
+}
@@ -0,0 +1,65 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+
+	"github.com/SkyAPM/go2sky/propagation"
+)
+
+type ctxCorrelationKey struct{}
+
+var ctxKeyCorrelation = ctxCorrelationKey{}
+
+// PutCorrelation returns a copy of ctx in which the correlation context holds
+// the key and value. It returns false with the original ctx when the key or
+// value exceeds the limits of propagation.CorrelationMaxKeyCount and
+// propagation.CorrelationMaxValueSize.
+func PutCorrelation(ctx context.Context, key string, value string) (context.Context, bool) {
+	correlation := correlationFromContext(ctx)
+	if !propagation.CorrelationAcceptable(correlation, key, value) {
+		return ctx, false
+	}
+	nc := make(map[string]string, len(correlation)+1)
+	for k, v := range correlation {
+		nc[k] = v
+	}
+	nc[key] = value
+	return context.WithValue(ctx, ctxKeyCorrelation, nc), true
+}
+
+// GetCorrelation returns the value of key in the correlation context of ctx.
+func GetCorrelation(ctx context.Context, key string) string {
+	return correlationFromContext(ctx)[key]
+}
+
+// Correlation returns a copy of the correlation context of ctx.
+func Correlation(ctx context.Context) map[string]string {
+	correlation := correlationFromContext(ctx)
+	c := make(map[string]string, len(correlation))
+	for k, v := range correlation {
+		c[k] = v
+	}
+	return c
+}
+
+func correlationFromContext(ctx context.Context) map[string]string {
+	correlation, _ := ctx.Value(ctxKeyCorrelation).(map[string]string)
+	return correlation
+}
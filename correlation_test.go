@@ -0,0 +1,56 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SkyAPM/go2sky/propagation"
+)
+
+func TestPutCorrelation(t *testing.T) {
+	ctx := context.Background()
+	if GetCorrelation(ctx, "tenant") != "" {
+		t.Error("correlation should be empty")
+	}
+	ctx, ok := PutCorrelation(ctx, "tenant", "acme")
+	if !ok || GetCorrelation(ctx, "tenant") != "acme" {
+		t.Error("correlation is not put")
+	}
+	nCtx, ok := PutCorrelation(ctx, "tenant", "other")
+	if !ok || GetCorrelation(nCtx, "tenant") != "other" || GetCorrelation(ctx, "tenant") != "acme" {
+		t.Error("correlation should be overwritten in the new context only")
+	}
+	if _, ok := PutCorrelation(ctx, "long", strings.Repeat("v", propagation.CorrelationMaxValueSize+1)); ok {
+		t.Error("value exceeding the size limit should not be put")
+	}
+	for i := len(Correlation(ctx)); i < propagation.CorrelationMaxKeyCount; i++ {
+		ctx, ok = PutCorrelation(ctx, string(rune('a'+i)), "v")
+		if !ok {
+			t.Error("correlation is not put")
+		}
+	}
+	if _, ok := PutCorrelation(ctx, "exceed", "v"); ok {
+		t.Error("key exceeding the count limit should not be put")
+	}
+	if len(Correlation(ctx)) != propagation.CorrelationMaxKeyCount {
+		t.Error("correlation size is wrong")
+	}
+}
@@ -0,0 +1,90 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The keys of the dynamic configuration supported by tracer
+const (
+	ConfigSampleNPer3Secs = "agent.sample_n_per_3_secs"
+	ConfigTraceIgnorePath = "agent.trace.ignore_path"
+)
+
+// samplingConfig is the sampling configuration delivered by the reporter,
+// it is applied on top of the sampler set up by user.
+type samplingConfig struct {
+	uuid           string
+	base           Sampler
+	samplePer3Secs int
+	ignorePaths    []OperationMatcher
+}
+
+func (c *samplingConfig) sampler() Sampler {
+	sampler := c.base
+	if c.samplePer3Secs > 0 {
+		sampler = NewRateLimitingSampler(c.samplePer3Secs)
+	}
+	if len(c.ignorePaths) == 0 {
+		return sampler
+	}
+	rules := make([]SamplingRule, 0, len(c.ignorePaths))
+	for _, m := range c.ignorePaths {
+		rules = append(rules, SamplingRule{Matcher: m, Ignore: true})
+	}
+	return NewRuleSampler(sampler, rules...)
+}
+
+// handleConfiguration applies the sampling rate and trace ignore paths delivered by
+// the reporter, the configuration with invalid values is ignored.
+func (t *Tracer) handleConfiguration(cmd Command) {
+	c, ok := cmd.(*ConfigurationDiscoveryCommand)
+	if !ok {
+		return
+	}
+	t.samplerMu.Lock()
+	defer t.samplerMu.Unlock()
+	if t.samplingConfig != nil && c.UUID != "" && c.UUID == t.samplingConfig.uuid {
+		return
+	}
+	config := &samplingConfig{uuid: c.UUID, base: t.sampler}
+	if t.samplingConfig != nil {
+		config.base = t.samplingConfig.base
+	}
+	if v := strings.TrimSpace(c.Config[ConfigSampleNPer3Secs]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return
+		}
+		config.samplePer3Secs = n
+	}
+	for _, pattern := range strings.Split(c.Config[ConfigTraceIgnorePath], ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		m, err := NewGlobMatcher(pattern)
+		if err != nil {
+			return
+		}
+		config.ignorePaths = append(config.ignorePaths, m)
+	}
+	t.samplingConfig = config
+	t.sampler = config.sampler()
+}
@@ -0,0 +1,84 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"testing"
+)
+
+type mockDispatcherReporter struct {
+	mockRegisterReporter
+	handlers map[string]CommandHandler
+}
+
+func (r *mockDispatcherReporter) RegisterCommandHandler(name string, handler CommandHandler) {
+	if r.handlers == nil {
+		r.handlers = make(map[string]CommandHandler)
+	}
+	r.handlers[name] = handler
+}
+
+func TestTracer_HandleConfiguration(t *testing.T) {
+	reporter := &mockDispatcherReporter{}
+	tracer, _ := NewTracer("service", WithReporter(reporter))
+	handler := reporter.handlers[CommandConfigurationDiscovery]
+	if handler == nil {
+		t.Fatal("tracer should register the configuration handler")
+	}
+	sampled := func(operation string) bool {
+		span, _, err := tracer.CreateLocalSpan(context.Background(), WithOperationName(operation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := span.(*NoopSpan)
+		return !ok
+	}
+
+	handler(&ConfigurationDiscoveryCommand{UUID: "1", Config: map[string]string{
+		ConfigSampleNPer3Secs: "1",
+		ConfigTraceIgnorePath: "/GET/healthz, /GET/static/**",
+	}})
+	if sampled("/GET/healthz") || sampled("/GET/static/js/app.js") {
+		t.Error("ignored paths should not be sampled")
+	}
+	if !sampled("/GET/api") || sampled("/GET/api") {
+		t.Error("1 segment should be sampled per 3 seconds")
+	}
+
+	handler(&ConfigurationDiscoveryCommand{UUID: "2", Config: map[string]string{
+		ConfigSampleNPer3Secs: "invalid",
+	}})
+	if sampled("/GET/healthz") {
+		t.Error("invalid configuration should be ignored")
+	}
+
+	tracer.SetSampler(NewConstSampler(true))
+	if sampled("/GET/healthz") {
+		t.Error("configuration should take effect on the sampler set by user")
+	}
+
+	handler(&ConfigurationDiscoveryCommand{UUID: "3", Config: map[string]string{}})
+	if !sampled("/GET/healthz") || !sampled("/GET/api") || !sampled("/GET/api") {
+		t.Error("sampler set by user should be restored")
+	}
+	tracer.SetSampler(NewConstSampler(false))
+	if sampled("/GET/api") {
+		t.Error("sampler set by user should be used")
+	}
+}
@@ -0,0 +1,113 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Go runs f in a new goroutine with a local span named name, which continues
+// the trace of the active span in ctx. The error returned by f or the panic is
+// recorded on the span, and the span is ended when f returns. The panic is raised
+// again with the original value after the span is ended.
+func Go(ctx context.Context, tracer *Tracer, name string, f func(ctx context.Context) error) {
+	snapshot := Capture(ctx)
+	go func() {
+		_ = runTraced(ctx, tracer, snapshot, name, f)
+	}()
+}
+
+// Group is a collection of goroutines traced by Go, which works as errgroup.Group.
+// The first error returned by the goroutines cancels the context of the group.
+type Group struct {
+	tracer   *Tracer
+	ctx      context.Context
+	cancel   func()
+	snapshot *ContextSnapshot
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup returns a new Group and a derived context of ctx,
+// the spans of the goroutines continue the trace of the active span in ctx.
+func NewGroup(ctx context.Context, tracer *Tracer) (*Group, context.Context) {
+	gCtx, cancel := context.WithCancel(ctx)
+	return &Group{
+		tracer:   tracer,
+		ctx:      gCtx,
+		cancel:   cancel,
+		snapshot: Capture(ctx),
+	}, gCtx
+}
+
+// Go calls f in a new goroutine with a local span named name.
+func (g *Group) Go(name string, f func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := runTraced(g.ctx, g.tracer, g.snapshot, name, f); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait blocks until all goroutines of the group return,
+// then returns the first error of them.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func runTraced(ctx context.Context, tracer *Tracer, snapshot *ContextSnapshot, name string, f func(ctx context.Context) error) (err error) {
+	var span Span
+	if tracer != nil {
+		var spanCtx context.Context
+		span, spanCtx, err = tracer.Continue(ctx, snapshot, WithOperationName(name))
+		if err != nil {
+			span = nil
+		} else {
+			ctx = spanCtx
+		}
+	}
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+		if span != nil {
+			if err != nil {
+				span.Error(time.Now(), err.Error())
+			}
+			span.End()
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	return f(ctx)
+}
@@ -0,0 +1,152 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestGo(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(2)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("service", WithReporter(&mr))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("root"))
+	if err != nil {
+		t.Error(err)
+	}
+	// Go runs runTraced in the goroutine, the panic is recovered here to assert it is raised again
+	recovered := make(chan interface{})
+	go func() {
+		defer func() {
+			recovered <- recover()
+		}()
+		_ = runTraced(ctx, tracer, Capture(ctx), "worker", func(ctx context.Context) error {
+			panic("worker panic")
+		})
+	}()
+	if r := <-recovered; r != "worker panic" {
+		t.Errorf("expect panic %v, actual %v", "worker panic", r)
+	}
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range mr.Message {
+		s := segment[0]
+		if s.OperationName() != "worker" {
+			continue
+		}
+		if !s.IsError() {
+			t.Error("panic is not recorded")
+		}
+		if s.Context().CrossThreadRef == nil {
+			t.Error("worker span is not linked to the caller")
+		}
+		return
+	}
+	t.Error("worker span is not reported")
+}
+
+func TestGo_CrossThreadRef(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(2)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("service", WithReporter(&mr))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := make(chan struct{})
+	Go(ctx, tracer, "worker", func(ctx context.Context) error {
+		<-start
+		return nil
+	})
+	// The snapshot is captured by Go, the worker runs after the caller span ends
+	span.End()
+	close(start)
+	reportWg.Wait()
+	if err := mr.Verify(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	root := span.(ReportedSpan).Context()
+	for _, segment := range mr.Message {
+		s := segment[0]
+		if s.OperationName() != "worker" {
+			continue
+		}
+		ref := s.Context().CrossThreadRef
+		if ref == nil {
+			t.Fatal("worker span is not linked to the caller")
+		}
+		if ref.TraceID != root.TraceID || ref.SegmentID != root.SegmentID || ref.SpanID != root.SpanID {
+			t.Errorf("worker span is linked to %+v, want the caller span", ref)
+		}
+		if s.Context().TraceID != root.TraceID {
+			t.Error("worker span should continue the trace of the caller")
+		}
+		return
+	}
+	t.Error("worker span is not reported")
+}
+
+func TestGroup(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(3)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("service", WithReporter(&mr))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("root"))
+	if err != nil {
+		t.Error(err)
+	}
+	errWorker := errors.New("worker error")
+	g, gCtx := NewGroup(ctx, tracer)
+	g.Go("ok", func(ctx context.Context) error {
+		return nil
+	})
+	g.Go("failed", func(ctx context.Context) error {
+		return errWorker
+	})
+	if err := g.Wait(); err != errWorker {
+		t.Errorf("expect error %v, actual %v", errWorker, err)
+	}
+	if gCtx.Err() == nil {
+		t.Error("group context should be canceled")
+	}
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range mr.Message {
+		s := segment[0]
+		if s.IsError() != (s.OperationName() == "failed") {
+			t.Errorf("error of span %s is not recorded properly", s.OperationName())
+		}
+	}
+}
@@ -24,6 +24,9 @@ import (
 )
 
 type NoopSpan struct {
+	// the span of the segment truncated by the span limit, whose context is
+	// still injected by the exit spans
+	limited segmentSpan
 }
 
 func (*NoopSpan) SetOperationName(string) {
@@ -61,3 +64,9 @@ func (*NoopSpan) IsEntry() bool {
 func (*NoopSpan) IsExit() bool {
 	return false
 }
+
+func (*NoopSpan) PrepareAsync() {
+}
+
+func (*NoopSpan) AsyncFinish() {
+}
@@ -92,8 +92,15 @@ type transport struct {
 }
 
 func (t *transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	if correlation := go2sky.Correlation(req.Context()); len(correlation) > 0 {
+		req.Header.Set(propagation.CorrelationHeader, propagation.EncodeSW8Correlation(correlation))
+	}
 	span, err := t.tracer.CreateExitSpan(req.Context(), getOperationName(t.name, req), req.Host, func(header string) error {
 		req.Header.Set(propagation.Header, header)
+		if go2sky.IsSkipAnalysis(req.Context()) {
+			ext := &propagation.Extension{TracingMode: propagation.TracingModeSkipAnalysis}
+			req.Header.Set(propagation.ExtensionHeader, ext.EncodeSW8Extension())
+		}
 		return nil
 	})
 	if err != nil {
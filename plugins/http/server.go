@@ -18,6 +18,8 @@
 package http
 
 import (
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -35,11 +37,16 @@ const (
 
 const componentIDGOHttpServer = 5004
 
+const tagForceSampled go2sky.Tag = "force_sampled"
+
 type handler struct {
 	tracer    *go2sky.Tracer
 	name      string
 	next      http.Handler
 	extraTags map[string]string
+	// the header and token to force sampling the request
+	forceSampleHeader string
+	forceSampleToken  string
 }
 
 // ServerOption allows Middleware to be optionally configured.
@@ -55,6 +62,18 @@ func WithServerTag(key string, value string) ServerOption {
 	}
 }
 
+// WithServerForceSampleHeader forces sampling the requests whose header value is the token,
+// which is used to trace the request for debugging. The option is ignored when header or token is empty.
+func WithServerForceSampleHeader(header string, token string) ServerOption {
+	return func(h *handler) {
+		if header == "" || token == "" {
+			return
+		}
+		h.forceSampleHeader = header
+		h.forceSampleToken = token
+	}
+}
+
 // WithOperationName override default operation name.
 func WithServerOperationName(name string) ServerOption {
 	return func(h *handler) {
@@ -81,9 +100,15 @@ func NewServerMiddleware(tracer *go2sky.Tracer, options ...ServerOption) (func(h
 
 // ServeHTTP implements http.Handler.
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(extractCorrelation(r))
+	opts := extractExtension(r)
+	forceSampled := h.isForceSampled(r)
+	if forceSampled {
+		opts = append(opts, go2sky.WithForceSample())
+	}
 	span, ctx, err := h.tracer.CreateEntrySpan(r.Context(), getOperationName(h.name, r), func() (string, error) {
 		return r.Header.Get(propagation.Header), nil
-	})
+	}, opts...)
 	if err != nil {
 		if h.next != nil {
 			h.next.ServeHTTP(w, r)
@@ -94,6 +119,9 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for k, v := range h.extraTags {
 		span.Tag(go2sky.Tag(k), v)
 	}
+	if forceSampled {
+		span.Tag(tagForceSampled, "true")
+	}
 	span.Tag(go2sky.TagHTTPMethod, r.Method)
 	span.Tag(go2sky.TagURL, fmt.Sprintf("%s%s", r.Host, r.URL.Path))
 	span.SetSpanLayer(v3.SpanLayer_Http)
@@ -130,6 +158,37 @@ func (rww *responseWriterWrapper) WriteHeader(statusCode int) {
 	rww.w.WriteHeader(statusCode)
 }
 
+func (h handler) isForceSampled(r *http.Request) bool {
+	if h.forceSampleHeader == "" {
+		return false
+	}
+	value := r.Header.Get(h.forceSampleHeader)
+	return value != "" && subtle.ConstantTimeCompare([]byte(value), []byte(h.forceSampleToken)) == 1
+}
+
+func extractCorrelation(r *http.Request) context.Context {
+	ctx := r.Context()
+	correlation, err := propagation.DecodeSW8Correlation(r.Header.Get(propagation.CorrelationHeader))
+	if err != nil {
+		return ctx
+	}
+	for k, v := range correlation {
+		ctx, _ = go2sky.PutCorrelation(ctx, k, v)
+	}
+	return ctx
+}
+
+func extractExtension(r *http.Request) (opts []go2sky.SpanOption) {
+	ext := &propagation.Extension{}
+	if err := ext.DecodeSW8Extension(r.Header.Get(propagation.ExtensionHeader)); err != nil {
+		return
+	}
+	if ext.IsSkipAnalysis() {
+		opts = append(opts, go2sky.WithSkipAnalysis())
+	}
+	return
+}
+
 func getOperationName(name string, r *http.Request) string {
 	if name == "" {
 		return fmt.Sprintf("/%s%s", r.Method, r.URL.Path)
@@ -0,0 +1,116 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SkyAPM/go2sky"
+)
+
+type mockReporter struct{}
+
+func (*mockReporter) Boot(service string, serviceInstance string) {}
+
+func (*mockReporter) Send(spans []go2sky.ReportedSpan) {}
+
+func (*mockReporter) Close() {}
+
+func TestServerForceSampleHeader(t *testing.T) {
+	tracer, err := go2sky.NewTracer("service", go2sky.WithReporter(&mockReporter{}), go2sky.WithSampler(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close(context.Background())
+	sm, err := NewServerMiddleware(tracer, WithServerForceSampleHeader("X-Debug-Trace", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		value  string
+		traced bool
+	}{
+		{"without header", "", false},
+		{"wrong token", "guess", false},
+		{"right token", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var span go2sky.Span
+			h := sm(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				span = go2sky.ActiveSpan(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/debug", nil)
+			if tt.value != "" {
+				req.Header.Set("X-Debug-Trace", tt.value)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if _, ok := span.(*go2sky.NoopSpan); ok == tt.traced {
+				t.Errorf("request traced %v, want %v", !ok, tt.traced)
+			}
+		})
+	}
+}
+
+type captureReporter struct {
+	spans chan []go2sky.ReportedSpan
+}
+
+func (*captureReporter) Boot(service string, serviceInstance string) {}
+
+func (r *captureReporter) Send(spans []go2sky.ReportedSpan) {
+	r.spans <- spans
+}
+
+func (*captureReporter) Close() {}
+
+func TestServerTagOverridesDefaultTags(t *testing.T) {
+	reporter := &captureReporter{spans: make(chan []go2sky.ReportedSpan, 1)}
+	tracer, err := go2sky.NewTracer("service", go2sky.WithReporter(reporter),
+		go2sky.WithDefaultTags(map[string]string{"version": "v1", "cluster": "c1"}),
+		go2sky.WithEntrySpanTags(map[string]string{"canary": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close(context.Background())
+	sm, err := NewServerMiddleware(tracer, WithServerTag("version", "v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sm(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		go2sky.ActiveSpan(r.Context()).Tag("cluster", "c2")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tags", nil))
+	spans := <-reporter.spans
+	tags := make(map[string]string)
+	for _, tag := range spans[0].Tags() {
+		if _, ok := tags[tag.Key]; ok {
+			t.Errorf("duplicated tag %s", tag.Key)
+		}
+		tags[tag.Key] = tag.Value
+	}
+	for k, want := range map[string]string{"version": "v2", "cluster": "c2", "canary": "true"} {
+		if tags[k] != want {
+			t.Errorf("tag %s = %s, want %s", k, tags[k], want)
+		}
+	}
+}
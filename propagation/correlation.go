@@ -0,0 +1,85 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package propagation
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CorrelationHeader is the header of correlation context
+	CorrelationHeader string = "sw8-correlation"
+	// CorrelationMaxKeyCount is the max number of keys in correlation context
+	CorrelationMaxKeyCount int = 3
+	// CorrelationMaxValueSize is the max length of values in correlation context
+	CorrelationMaxValueSize int = 128
+
+	correlationSplitToken   string = ","
+	correlationKVSplitToken string = ":"
+)
+
+// DecodeSW8Correlation decodes the sw8-correlation header. The entries exceeding
+// CorrelationMaxKeyCount and the values longer than CorrelationMaxValueSize are ignored.
+func DecodeSW8Correlation(header string) (map[string]string, error) {
+	correlation := make(map[string]string)
+	if header == "" {
+		return correlation, nil
+	}
+	for _, entry := range strings.Split(header, correlationSplitToken) {
+		kv := strings.Split(entry, correlationKVSplitToken)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid correlation entry: %s", entry)
+		}
+		key, err := decodeBase64(kv[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "correlation key parse error")
+		}
+		value, err := decodeBase64(kv[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "correlation value parse error")
+		}
+		if !CorrelationAcceptable(correlation, key, value) {
+			continue
+		}
+		correlation[key] = value
+	}
+	return correlation, nil
+}
+
+// EncodeSW8Correlation encodes the correlation context into sw8-correlation header.
+func EncodeSW8Correlation(correlation map[string]string) string {
+	entries := make([]string, 0, len(correlation))
+	for k, v := range correlation {
+		entries = append(entries, encodeBase64(k)+correlationKVSplitToken+encodeBase64(v))
+	}
+	return strings.Join(entries, correlationSplitToken)
+}
+
+// CorrelationAcceptable checks whether the key and value could be put into
+// the correlation context within the limits.
+func CorrelationAcceptable(correlation map[string]string, key string, value string) bool {
+	if key == "" || len(value) > CorrelationMaxValueSize {
+		return false
+	}
+	if _, ok := correlation[key]; ok {
+		return true
+	}
+	return len(correlation) < CorrelationMaxKeyCount
+}
@@ -0,0 +1,91 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package propagation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSW8Correlation(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   map[string]string{},
+		},
+		{
+			name:   "normal",
+			header: "dGVuYW50:YWNtZQ==,YWI=:Qg==",
+			want:   map[string]string{"tenant": "acme", "ab": "B"},
+		},
+		{
+			name:   "exceed key count",
+			header: "YQ==:MQ==,Yg==:Mg==,Yw==:Mw==,ZA==:NA==",
+			want:   map[string]string{"a": "1", "b": "2", "c": "3"},
+		},
+		{
+			name:   "exceed value size",
+			header: "YQ==:" + encodeBase64(strings.Repeat("v", CorrelationMaxValueSize+1)),
+			want:   map[string]string{},
+		},
+		{
+			name:    "invalid entry",
+			header:  "dGVuYW50",
+			wantErr: true,
+		},
+		{
+			name:    "invalid base64",
+			header:  "dGVuYW50:!!",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSW8Correlation(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeSW8Correlation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeSW8Correlation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeSW8Correlation(t *testing.T) {
+	correlation := map[string]string{"tenant": "acme"}
+	header := EncodeSW8Correlation(correlation)
+	if header != "dGVuYW50:YWNtZQ==" {
+		t.Errorf("EncodeSW8Correlation() = %v", header)
+	}
+	got, err := DecodeSW8Correlation(header)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(got, correlation) {
+		t.Errorf("decoded correlation %v, want %v", got, correlation)
+	}
+}
@@ -0,0 +1,69 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package propagation
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExtensionHeader is the header of extension context
+const ExtensionHeader string = "sw8-x"
+
+// TracingMode is the tracing mode of the trace in extension context
+type TracingMode int8
+
+const (
+	// TracingModeNormal means the trace is analyzed by OAP server
+	TracingModeNormal TracingMode = 0
+	// TracingModeSkipAnalysis means the trace is stored, but not analyzed by OAP server
+	TracingModeSkipAnalysis TracingMode = 1
+)
+
+// Extension is the extension context carried by sw8-x header
+type Extension struct {
+	TracingMode TracingMode `json:"tracing_mode"`
+}
+
+// DecodeSW8Extension decodes the sw8-x header, the unknown fields are ignored.
+func (e *Extension) DecodeSW8Extension(header string) error {
+	if header == "" {
+		return nil
+	}
+	ee := strings.Split(header, splitToken)
+	if ee[0] != "" {
+		mode, err := strconv.ParseInt(ee[0], 10, 8)
+		if err != nil {
+			return errors.Errorf("str to int8 error %s", ee[0])
+		}
+		e.TracingMode = TracingMode(mode)
+	}
+	return nil
+}
+
+// EncodeSW8Extension encodes the extension context into sw8-x header
+func (e *Extension) EncodeSW8Extension() string {
+	return strconv.Itoa(int(e.TracingMode))
+}
+
+// IsSkipAnalysis returns true when the trace is not analyzed by OAP server
+func (e *Extension) IsSkipAnalysis() bool {
+	return e.TracingMode == TracingModeSkipAnalysis
+}
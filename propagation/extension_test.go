@@ -0,0 +1,77 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package propagation
+
+import "testing"
+
+func TestExtension_DecodeSW8Extension(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    TracingMode
+		wantErr bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   TracingModeNormal,
+		},
+		{
+			name:   "skip analysis",
+			header: "1",
+			want:   TracingModeSkipAnalysis,
+		},
+		{
+			name:   "unknown fields",
+			header: "1-1592979621333",
+			want:   TracingModeSkipAnalysis,
+		},
+		{
+			name:   "empty tracing mode",
+			header: "-1592979621333",
+			want:   TracingModeNormal,
+		},
+		{
+			name:    "invalid tracing mode",
+			header:  "a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Extension{}
+			if err := e.DecodeSW8Extension(tt.header); (err != nil) != tt.wantErr {
+				t.Errorf("DecodeSW8Extension() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if e.TracingMode != tt.want {
+				t.Errorf("DecodeSW8Extension() = %v, want %v", e.TracingMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtension_EncodeSW8Extension(t *testing.T) {
+	e := &Extension{TracingMode: TracingModeSkipAnalysis}
+	if got := e.EncodeSW8Extension(); got != "1" {
+		t.Errorf("EncodeSW8Extension() = %v, want 1", got)
+	}
+	if !e.IsSkipAnalysis() {
+		t.Error("extension should skip analysis")
+	}
+}
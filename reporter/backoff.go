@@ -0,0 +1,134 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	defaultBackoffBase = time.Second
+	defaultBackoffMax  = 30 * time.Second
+	backoffMultiplier  = 2
+	// the delay is randomized by ±20%
+	backoffJitter = 0.2
+)
+
+// WithBackoff setup the exponential backoff of reopening the stream and retrying the management calls,
+// the delay starts from base and doubles on every failure until max.
+func WithBackoff(base time.Duration, max time.Duration) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.backoffBase = base
+		r.backoffMax = max
+	}
+}
+
+// WithConnectionStateListener setup the listener of the connection state transitions,
+// which is invoked in order by a dedicated goroutine until the connection is shut down.
+func WithConnectionStateListener(listener func(from connectivity.State, to connectivity.State)) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.stateListener = listener
+	}
+}
+
+// backoff computes the delays between retries, it is not safe for concurrent use
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	attempts int
+}
+
+func (r *gRPCReporter) newBackoff() *backoff {
+	b := &backoff{base: r.backoffBase, max: r.backoffMax}
+	if b.base <= 0 {
+		b.base = defaultBackoffBase
+	}
+	if b.max <= 0 {
+		b.max = defaultBackoffMax
+	}
+	if b.max < b.base {
+		b.max = b.base
+	}
+	return b
+}
+
+// next returns the delay before the next retry
+func (b *backoff) next() time.Duration {
+	d := float64(b.base)
+	for i := 0; i < b.attempts && d < float64(b.max); i++ {
+		d *= backoffMultiplier
+	}
+	if d > float64(b.max) {
+		d = float64(b.max)
+	} else {
+		b.attempts++
+	}
+	d *= 1 + backoffJitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+// reset restarts the delay from base after a successful call
+func (b *backoff) reset() {
+	b.attempts = 0
+}
+
+// wait waits for d, it returns early when the connection turns ready or shut down,
+// or stop is closed.
+func (r *gRPCReporter) wait(d time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if stop != nil {
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if r.conn == nil {
+		<-ctx.Done()
+		return
+	}
+	state := r.conn.GetState()
+	if state == connectivity.Shutdown {
+		return
+	}
+	for r.conn.WaitForStateChange(ctx, state) {
+		state = r.conn.GetState()
+		if state == connectivity.Ready || state == connectivity.Shutdown {
+			return
+		}
+	}
+}
+
+func (r *gRPCReporter) watchState() {
+	state := r.conn.GetState()
+	for state != connectivity.Shutdown {
+		if !r.conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		to := r.conn.GetState()
+		r.stateListener(state, to)
+		state = to
+	}
+}
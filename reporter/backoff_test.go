@@ -0,0 +1,256 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	reporter := createGRPCReporter()
+	WithBackoff(100*time.Millisecond, time.Second)(reporter)
+	bo := reporter.newBackoff()
+	within := func(d time.Duration, want time.Duration) bool {
+		return float64(d) >= float64(want)*(1-backoffJitter) && float64(d) <= float64(want)*(1+backoffJitter)
+	}
+	for _, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if d := bo.next(); !within(d, want*time.Millisecond) {
+			t.Errorf("backoff delay %v, want about %v", d, want*time.Millisecond)
+		}
+	}
+	bo.reset()
+	if d := bo.next(); !within(d, 100*time.Millisecond) {
+		t.Errorf("backoff delay %v should be reset", d)
+	}
+
+	bo = createGRPCReporter().newBackoff()
+	if bo.base != defaultBackoffBase || bo.max != defaultBackoffMax {
+		t.Error("default backoff is not set")
+	}
+}
+
+func TestGRPCReporter_ConnectionStateListener(t *testing.T) {
+	var mu sync.Mutex
+	var transitions []connectivity.State
+	shutdown := make(chan struct{})
+	listener := func(from connectivity.State, to connectivity.State) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, to)
+		if to == connectivity.Shutdown {
+			close(shutdown)
+		}
+	}
+	// Nothing listens on the port
+	r, err := NewGRPCReporter("127.0.0.1:1", WithConnectionStateListener(listener), WithCheckInterval(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := r.(*gRPCReporter)
+	start := time.Now()
+	reporter.wait(time.Second, nil)
+	if state := reporter.conn.GetState(); state == connectivity.Ready {
+		t.Errorf("connection should not be ready")
+	}
+	if time.Since(start) < time.Second {
+		t.Error("wait should not return before the connection is ready")
+	}
+	reporter.Close()
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown is not notified")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(transitions) < 2 {
+		t.Errorf("transitions %v should be notified", transitions)
+	}
+	// wait returns at once after the connection is shut down
+	reporter.wait(time.Minute, nil)
+}
+
+type lineCounter struct {
+	mu    sync.Mutex
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines++
+	return len(p), nil
+}
+
+func (c *lineCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lines
+}
+
+func TestGRPCReporter_CloseUnreachable(t *testing.T) {
+	counter := &lineCounter{}
+	// Nothing listens on the port
+	r, err := NewGRPCReporter("127.0.0.1:1", WithCheckInterval(-1),
+		WithLogger(log.New(counter, "", 0)), WithBackoff(10*time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := r.(*gRPCReporter)
+	reporter.Boot(mockService, mockServiceInstance)
+	time.Sleep(50 * time.Millisecond)
+	reporter.Close()
+	select {
+	case <-reporter.pipelineDone:
+	case <-time.After(time.Second):
+		t.Fatal("send pipeline should exit after close")
+	}
+	n := counter.count()
+	time.Sleep(100 * time.Millisecond)
+	if counter.count() != n {
+		t.Errorf("%d lines are logged after close", counter.count()-n)
+	}
+}
+
+type rejectingCollector struct {
+	mu      sync.Mutex
+	streams int
+}
+
+func (c *rejectingCollector) Collect(stream agentv3.TraceSegmentReportService_CollectServer) error {
+	c.mu.Lock()
+	c.streams++
+	c.mu.Unlock()
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+func (c *rejectingCollector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams
+}
+
+func TestGRPCReporter_RejectedStream(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &rejectingCollector{}
+	s := grpc.NewServer()
+	agentv3.RegisterTraceSegmentReportServiceServer(s, c)
+	go s.Serve(lis)
+	defer s.Stop()
+	r, err := NewGRPCReporter(lis.Addr().String(), WithCheckInterval(-1), WithRetryWindow(5),
+		WithLogger(log.New(&lineCounter{}, "", 0)), WithBackoff(20*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := r.(*gRPCReporter)
+	reporter.Boot(mockService, mockServiceInstance)
+	stop := time.After(time.Second)
+Feed:
+	for {
+		select {
+		case <-stop:
+			break Feed
+		case reporter.sendCh <- &agentv3.SegmentObject{}:
+		case <-time.After(time.Millisecond):
+		}
+	}
+	// 20ms, 40ms, 80ms, then 100ms at most between the streams
+	if n := c.count(); n == 0 || n > 15 {
+		t.Errorf("%d streams are opened in 1 second", n)
+	}
+	if dropped := reporter.Stats().SendDropped; dropped < 5 {
+		t.Errorf("%d segments are counted as dropped, want at least 5", dropped)
+	}
+	reporter.Close()
+}
+
+func TestGRPCReporter_FlushUnreachable(t *testing.T) {
+	r, err := NewGRPCReporter("127.0.0.1:1", WithCheckInterval(-1), WithBackoff(time.Minute, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := r.(*gRPCReporter)
+	tracer, err := go2sky.NewTracer(mockService, go2sky.WithReporter(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		reporter.sendCh <- &agentv3.SegmentObject{}
+	}
+	span, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- tracer.Close(context.Background())
+	}()
+	select {
+	case <-done:
+		t.Fatal("close should wait for the open segment")
+	case <-time.After(50 * time.Millisecond):
+	}
+	span.End()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("close should return error when segments are dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close should give up the unreachable backend")
+	}
+	if dropped := reporter.Stats().SendDropped; dropped < 3 {
+		t.Errorf("%d segments are counted as dropped, want at least 3", dropped)
+	}
+	// Report after the queue is closed
+	capture := &captureReporter{spans: make(chan []go2sky.ReportedSpan, 1)}
+	tracer, _ = go2sky.NewTracer(mockService, go2sky.WithReporter(capture))
+	span, _, _ = tracer.CreateLocalSpan(context.Background())
+	span.End()
+	reporter.Send(<-capture.spans)
+	if dropped := reporter.Stats().QueueDropped; dropped != 1 {
+		t.Errorf("%d segments are counted as dropped after close, want 1", dropped)
+	}
+}
+
+type captureReporter struct {
+	spans chan []go2sky.ReportedSpan
+}
+
+func (*captureReporter) Boot(service string, serviceInstance string) {}
+
+func (r *captureReporter) Send(spans []go2sky.ReportedSpan) {
+	r.spans <- spans
+}
+
+func (*captureReporter) Close() {}
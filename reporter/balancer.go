@@ -0,0 +1,83 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+const (
+	backendsScheme                 = "go2sky"
+	defaultStreamRebalanceInterval = 5 * time.Minute
+	defaultLoadBalancePolicy       = RoundRobin
+)
+
+// LoadBalancePolicy is the policy to balance the streams across OAP backends
+type LoadBalancePolicy string
+
+const (
+	// RoundRobin opens the streams to the backends in turn
+	RoundRobin LoadBalancePolicy = "round_robin"
+	// PickFirst opens the streams to the first reachable backend
+	PickFirst LoadBalancePolicy = "pick_first"
+)
+
+// WithLoadBalancePolicy setup the policy to balance the streams across OAP backends
+func WithLoadBalancePolicy(policy LoadBalancePolicy) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.loadBalancePolicy = policy
+	}
+}
+
+// WithStreamRebalanceInterval setup the interval to reopen the stream,
+// so that the segments are rebalanced across OAP backends. interval <= 0 disables the rebalance.
+func WithStreamRebalanceInterval(interval time.Duration) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.rebalanceInterval = interval
+	}
+}
+
+// dialBackends dials the comma separated backend addresses, or a target name
+// resolved by gRPC, eg: dns:///oap-skywalking:11800.
+func (r *gRPCReporter) dialBackends(serverAddr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingPolicy":%q}`, r.loadBalancePolicy)))
+	var addrs []resolver.Address
+	for _, addr := range strings.Split(serverAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, resolver.Address{Addr: addr})
+		}
+	}
+	if len(addrs) <= 1 {
+		return grpc.Dial(strings.TrimSpace(serverAddr), opts...)
+	}
+	backends := manual.NewBuilderWithScheme(backendsScheme)
+	backends.InitialState(resolver.State{Addresses: addrs})
+	// The first address is the authority of the connection
+	return grpc.Dial(backendsScheme+":///"+addrs[0].Addr, append(opts, grpc.WithResolvers(backends))...)
+}
+
+// streamExpired returns true when the stream opened at openedAt should be rebalanced
+func (r *gRPCReporter) streamExpired(openedAt time.Time) bool {
+	return r.rebalanceInterval > 0 && time.Since(openedAt) >= r.rebalanceInterval
+}
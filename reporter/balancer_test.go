@@ -0,0 +1,120 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+	"google.golang.org/grpc"
+)
+
+type mockCollector struct {
+	mu       sync.Mutex
+	segments map[string]bool
+}
+
+func (c *mockCollector) Collect(stream agentv3.TraceSegmentReportService_CollectServer) error {
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&common.Commands{})
+		}
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.segments[s.TraceSegmentId] = true
+		c.mu.Unlock()
+	}
+}
+
+func (c *mockCollector) received() map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]bool, len(c.segments))
+	for k, v := range c.segments {
+		m[k] = v
+	}
+	return m
+}
+
+func startCollector(t *testing.T) (*mockCollector, *grpc.Server, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &mockCollector{segments: make(map[string]bool)}
+	s := grpc.NewServer()
+	agentv3.RegisterTraceSegmentReportServiceServer(s, c)
+	go s.Serve(lis)
+	return c, s, lis.Addr().String()
+}
+
+func TestGRPCReporter_Backends(t *testing.T) {
+	c1, s1, addr1 := startCollector(t)
+	c2, s2, addr2 := startCollector(t)
+	defer s2.Stop()
+	r, err := NewGRPCReporter(addr1+", "+addr2, WithCheckInterval(-1), WithRetryWindow(1),
+		WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := r.(*gRPCReporter)
+	reporter.Boot(mockService, mockServiceInstance)
+	send := func(from, to int) {
+		for i := from; i < to; i++ {
+			reporter.sendCh <- &agentv3.SegmentObject{TraceSegmentId: fmt.Sprint(i)}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	send(0, 40)
+	if len(c1.received()) == 0 || len(c2.received()) == 0 {
+		t.Errorf("segments should be balanced, %d and %d received", len(c1.received()), len(c2.received()))
+	}
+
+	s1.Stop()
+	send(40, 60)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = reporter.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	reporter.Close()
+	received := c2.received()
+	for k := range c1.received() {
+		received[k] = true
+	}
+	for i := 0; i < 60; i++ {
+		if !received[fmt.Sprint(i)] {
+			t.Errorf("segment %d is lost", i)
+		}
+	}
+	for i := 40; i < 60; i++ {
+		if !c2.received()[fmt.Sprint(i)] {
+			t.Errorf("segment %d should fail over to the alive backend", i)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"strconv"
+
+	"github.com/SkyAPM/go2sky"
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+	"github.com/pkg/errors"
+)
+
+// RegisterCommandHandler registers the handler of the commands named name,
+// which are returned by OAP server in the responses of management service.
+// The configuration discovery and profile task services are not polled, so
+// their commands are not delivered.
+func (r *gRPCReporter) RegisterCommandHandler(name string, handler go2sky.CommandHandler) {
+	if handler == nil {
+		return
+	}
+	r.commandMu.Lock()
+	defer r.commandMu.Unlock()
+	if r.commandHandlers == nil {
+		r.commandHandlers = make(map[string][]go2sky.CommandHandler)
+	}
+	r.commandHandlers[name] = append(r.commandHandlers[name], handler)
+}
+
+// dispatchCommands decodes the commands and delivers them to the registered handlers
+func (r *gRPCReporter) dispatchCommands(commands *common.Commands) {
+	if commands == nil {
+		return
+	}
+	for _, c := range commands.GetCommands() {
+		r.commandMu.RLock()
+		handlers := r.commandHandlers[c.GetCommand()]
+		r.commandMu.RUnlock()
+		if len(handlers) == 0 {
+			continue
+		}
+		cmd, err := decodeCommand(c)
+		if err != nil {
+			r.logger.Printf("decode command %s error %v", c.GetCommand(), err)
+			continue
+		}
+		for _, h := range handlers {
+			h(cmd)
+		}
+	}
+}
+
+func decodeCommand(c *common.Command) (go2sky.Command, error) {
+	args := make(map[string]string, len(c.GetArgs()))
+	for _, kv := range c.GetArgs() {
+		args[kv.GetKey()] = kv.GetValue()
+	}
+	switch c.GetCommand() {
+	case go2sky.CommandConfigurationDiscovery:
+		cmd := &go2sky.ConfigurationDiscoveryCommand{
+			SerialNumber: args["SerialNumber"],
+			UUID:         args["UUID"],
+			Config:       args,
+		}
+		delete(args, "SerialNumber")
+		delete(args, "UUID")
+		return cmd, nil
+	case go2sky.CommandProfileTask:
+		d := argsDecoder{args: args}
+		cmd := &go2sky.ProfileTaskCommand{
+			SerialNumber:         args["SerialNumber"],
+			TaskID:               args["TaskId"],
+			EndpointName:         args["EndpointName"],
+			Duration:             d.int("Duration"),
+			MinDurationThreshold: d.int("MinDurationThreshold"),
+			DumpPeriod:           d.int("DumpPeriod"),
+			MaxSamplingCount:     d.int("MaxSamplingCount"),
+			StartTime:            d.int64("StartTime"),
+			CreateTime:           d.int64("CreateTime"),
+		}
+		return cmd, d.err
+	default:
+		return &go2sky.RawCommand{Name: c.GetCommand(), Args: args}, nil
+	}
+}
+
+// argsDecoder parses the numeric arguments and keeps the first error
+type argsDecoder struct {
+	args map[string]string
+	err  error
+}
+
+func (d *argsDecoder) int(key string) int {
+	return int(d.int64(key))
+}
+
+func (d *argsDecoder) int64(key string) int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(d.args[key], 10, 64)
+	if err != nil {
+		d.err = errors.Wrapf(err, "invalid argument %s", key)
+	}
+	return n
+}
@@ -0,0 +1,77 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/SkyAPM/go2sky"
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+)
+
+func newCommand(name string, args ...string) *common.Command {
+	c := &common.Command{Command: name}
+	for i := 0; i+1 < len(args); i += 2 {
+		c.Args = append(c.Args, &common.KeyStringValuePair{Key: args[i], Value: args[i+1]})
+	}
+	return c
+}
+
+func TestGRPCReporter_dispatchCommands(t *testing.T) {
+	reporter := createGRPCReporter()
+	var received []go2sky.Command
+	handler := func(cmd go2sky.Command) {
+		received = append(received, cmd)
+	}
+	reporter.RegisterCommandHandler(go2sky.CommandConfigurationDiscovery, handler)
+	reporter.RegisterCommandHandler(go2sky.CommandProfileTask, handler)
+	reporter.RegisterCommandHandler("Custom", handler)
+	reporter.dispatchCommands(&common.Commands{Commands: []*common.Command{
+		newCommand(go2sky.CommandConfigurationDiscovery, "SerialNumber", "s1", "UUID", "u1",
+			go2sky.ConfigSampleNPer3Secs, "10"),
+		newCommand(go2sky.CommandProfileTask, "SerialNumber", "s2", "TaskId", "t1", "EndpointName", "/api",
+			"Duration", "5", "MinDurationThreshold", "100", "DumpPeriod", "10", "MaxSamplingCount", "5",
+			"StartTime", "1600000000000", "CreateTime", "1600000000001"),
+		newCommand(go2sky.CommandProfileTask, "Duration", "invalid"),
+		newCommand("Custom", "k", "v"),
+		newCommand("Unregistered"),
+	}})
+	want := []go2sky.Command{
+		&go2sky.ConfigurationDiscoveryCommand{
+			SerialNumber: "s1",
+			UUID:         "u1",
+			Config:       map[string]string{go2sky.ConfigSampleNPer3Secs: "10"},
+		},
+		&go2sky.ProfileTaskCommand{
+			SerialNumber:         "s2",
+			TaskID:               "t1",
+			EndpointName:         "/api",
+			Duration:             5,
+			MinDurationThreshold: 100,
+			DumpPeriod:           10,
+			MaxSamplingCount:     5,
+			StartTime:            1600000000000,
+			CreateTime:           1600000000001,
+		},
+		&go2sky.RawCommand{Name: "Custom", Args: map[string]string{"k": "v"}},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("dispatched commands %v, want %v", received, want)
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SkyAPM/go2sky"
@@ -33,25 +35,44 @@ import (
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+
+	"github.com/pkg/errors"
 )
 
 const (
-	maxSendQueueSize     int32 = 30000
-	defaultCheckInterval       = 20 * time.Second
-	defaultLogPrefix           = "go2sky-gRPC"
-	authKey                    = "Authentication"
+	maxSendQueueSize       int32 = 30000
+	defaultRetryWindowSize       = 1000
+	// the retry window is dropped after it fails to be delivered by maxWindowRetries streams
+	maxWindowRetries     = 3
+	defaultCheckInterval = 20 * time.Second
+	defaultLogPrefix     = "go2sky-gRPC"
+	authKey              = "Authentication"
 )
 
-// NewGRPCReporter create a new reporter to send data to gRPC oap server. Only one backend address is allowed.
+// NewGRPCReporter create a new reporter to send data to gRPC oap server. serverAddr could be
+// comma separated backend addresses, eg: oap-0:11800,oap-1:11800, or a name resolved to
+// many backends, eg: dns:///oap-skywalking:11800. The streams are balanced across the backends.
 func NewGRPCReporter(serverAddr string, opts ...GRPCReporterOption) (go2sky.Reporter, error) {
 	r := &gRPCReporter{
-		logger:        log.New(os.Stderr, defaultLogPrefix, log.LstdFlags),
-		sendCh:        make(chan *agentv3.SegmentObject, maxSendQueueSize),
-		checkInterval: defaultCheckInterval,
+		logger:            log.New(os.Stderr, defaultLogPrefix, log.LstdFlags),
+		sendCh:            make(chan *agentv3.SegmentObject, maxSendQueueSize),
+		checkInterval:     defaultCheckInterval,
+		retryWindowSize:   defaultRetryWindowSize,
+		loadBalancePolicy: defaultLoadBalancePolicy,
+		rebalanceInterval: defaultStreamRebalanceInterval,
+		queueBlockTimeout: defaultQueueBlockTimeout,
+		flushed:           make(chan struct{}),
 	}
 	for _, o := range opts {
 		o(r)
 	}
+	if r.spoolDir != "" {
+		spool, err := newDiskSpool(r.spoolDir, r.spoolMaxSize, r.spoolMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		r.spool = spool
+	}
 
 	var credsDialOption grpc.DialOption
 	if r.creds != nil {
@@ -61,11 +82,14 @@ func NewGRPCReporter(serverAddr string, opts ...GRPCReporterOption) (go2sky.Repo
 		credsDialOption = grpc.WithInsecure()
 	}
 
-	conn, err := grpc.Dial(serverAddr, credsDialOption)
+	conn, err := r.dialBackends(serverAddr, credsDialOption)
 	if err != nil {
 		return nil, err
 	}
 	r.conn = conn
+	if r.stateListener != nil {
+		go r.watchState()
+	}
 	r.traceClient = agentv3.NewTraceSegmentReportServiceClient(r.conn)
 	r.managementClient = managementv3.NewManagementServiceClient(r.conn)
 	return r, nil
@@ -117,7 +141,53 @@ func WithAuthentication(auth string) GRPCReporterOption {
 	}
 }
 
+// WithDiskSpool keeps the segments which cannot be sent in dir, when OAP server is unreachable
+// or the send queue is full. The spooled segments are sent in order after the stream is reconnected,
+// and kept for the next process when the reporter is closed. The oldest segments are dropped when
+// the spool exceeds maxSize bytes, and the segments spooled for maxAge expire. maxAge <= 0 means no age limit.
+func WithDiskSpool(dir string, maxSize int64, maxAge time.Duration) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.spoolDir = dir
+		r.spoolMaxSize = maxSize
+		r.spoolMaxAge = maxAge
+	}
+}
+
+// WithRetryWindow setup the max number of segments sent but not acknowledged by OAP server,
+// which are sent again when the stream is broken. The stream is closed to be acknowledged when
+// the window is full. The window is dropped, or kept in spool, after 3 streams fail to deliver it.
+// size <= 0 disables the retry, the segment failed to send is dropped.
+func WithRetryWindow(size int) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.retryWindowSize = size
+	}
+}
+
+// Stats is the counters of the segments dropped by the gRPC reporter
+type Stats struct {
+	// QueueDropped is the number of segments dropped by the queue full policy, or reported after the reporter is closed
+	QueueDropped int64
+	// SendDropped is the number of segments failed to send to OAP server and not retried,
+	// or not acknowledged after the retries
+	SendDropped int64
+	// SpoolDropped is the number of segments failed to be spooled, or removed from
+	// the spool when it is full or expired
+	SpoolDropped int64
+}
+
+// StatsReporter is a Reporter which counts the dropped segments, it is implemented by the gRPC reporter.
+type StatsReporter interface {
+	go2sky.Reporter
+	Stats() Stats
+}
+
 type gRPCReporter struct {
+	// the atomic counters are kept first for 64-bit alignment on 32-bit platforms
+	queueDropped int64
+	sendDropped  int64
+	// the segments taken from the send queue, but not acknowledged or dropped
+	inflight int64
+
 	service          string
 	serviceInstance  string
 	instanceProps    map[string]string
@@ -130,6 +200,39 @@ type gRPCReporter struct {
 
 	md    metadata.MD
 	creds credentials.TransportCredentials
+
+	// sendMu guards sendCh against sending after it is closed
+	sendMu       sync.RWMutex
+	sendChClosed bool
+	// closed with sendCh to stop waiting for reconnection
+	flushed       chan struct{}
+	connCloseOnce sync.Once
+	// closed when the send pipeline exits
+	pipelineDone chan struct{}
+
+	commandMu       sync.RWMutex
+	commandHandlers map[string][]go2sky.CommandHandler
+
+	spoolDir     string
+	spoolMaxSize int64
+	spoolMaxAge  time.Duration
+	// nil when the disk spool is disabled
+	spool *diskSpool
+
+	backoffBase   time.Duration
+	backoffMax    time.Duration
+	stateListener func(from connectivity.State, to connectivity.State)
+
+	loadBalancePolicy LoadBalancePolicy
+	rebalanceInterval time.Duration
+
+	retryWindowSize int
+	// accessed by the send pipeline only
+	retryWindow   []*agentv3.SegmentObject
+	windowRetries int
+
+	queueFullPolicy   QueueFullPolicy
+	queueBlockTimeout time.Duration
 }
 
 func (r *gRPCReporter) Boot(service string, serviceInstance string) {
@@ -152,6 +255,7 @@ func (r *gRPCReporter) Send(spans []go2sky.ReportedSpan) {
 		Spans:           make([]*agentv3.SpanObject, spanSize),
 		Service:         r.service,
 		ServiceInstance: r.serviceInstance,
+		IsSizeLimited:   rootCtx.IsSizeLimited(),
 	}
 	for i, s := range spans {
 		spanCtx := s.Context()
@@ -168,16 +272,18 @@ func (r *gRPCReporter) Send(spans []go2sky.ReportedSpan) {
 			IsError:       s.IsError(),
 			Tags:          s.Tags(),
 			Logs:          s.Logs(),
+			SkipAnalysis:  spanCtx.SkipAnalysis,
 		}
 		srr := make([]*agentv3.SegmentReference, 0)
-		if i == (spanSize-1) && spanCtx.ParentSpanID > -1 {
+		if i == (spanSize-1) && spanCtx.CrossThreadRef != nil {
 			srr = append(srr, &agentv3.SegmentReference{
 				RefType:               agentv3.RefType_CrossThread,
 				TraceId:               spanCtx.TraceID,
-				ParentTraceSegmentId:  spanCtx.ParentSegmentID,
-				ParentSpanId:          spanCtx.ParentSpanID,
+				ParentTraceSegmentId:  spanCtx.CrossThreadRef.SegmentID,
+				ParentSpanId:          spanCtx.CrossThreadRef.SpanID,
 				ParentService:         r.service,
 				ParentServiceInstance: r.serviceInstance,
+				ParentEndpoint:        spanCtx.CrossThreadRef.ParentEndpoint,
 			})
 		}
 		if len(s.Refs()) > 0 {
@@ -196,62 +302,292 @@ func (r *gRPCReporter) Send(spans []go2sky.ReportedSpan) {
 		}
 		segmentObject.Spans[i].Refs = srr
 	}
-	defer func() {
-		// recover the panic caused by close sendCh
-		if err := recover(); err != nil {
-			r.logger.Printf("reporter segment err %v", err)
+	r.sendMu.RLock()
+	defer r.sendMu.RUnlock()
+	if r.sendChClosed {
+		atomic.AddInt64(&r.queueDropped, 1)
+		r.logger.Printf("reporter is closed, segment dropped")
+		return
+	}
+	r.enqueue(segmentObject)
+}
+
+// Stats returns the counters of the dropped segments
+func (r *gRPCReporter) Stats() Stats {
+	stats := Stats{
+		QueueDropped: atomic.LoadInt64(&r.queueDropped),
+		SendDropped:  atomic.LoadInt64(&r.sendDropped),
+	}
+	if r.spool != nil {
+		stats.SpoolDropped = atomic.LoadInt64(&r.spool.dropped)
+	}
+	return stats
+}
+
+// dropped returns the number of segments failed to send or spool
+func (r *gRPCReporter) dropped() int64 {
+	stats := r.Stats()
+	return stats.SendDropped + stats.SpoolDropped
+}
+
+func (r *gRPCReporter) Close() {
+	r.closeSendCh()
+	r.closeGRPCConn()
+	if r.spool != nil && r.pipelineDone == nil {
+		r.spool.close()
+	}
+}
+
+// Flush stops accepting segments and waits for the queued segments
+// to be sent to the backend until ctx is done.
+func (r *gRPCReporter) Flush(ctx context.Context) error {
+	dropped := r.dropped()
+	r.closeSendCh()
+	if r.pipelineDone == nil {
+		if n := len(r.sendCh); n > 0 {
+			return errors.Errorf("%d segments dropped in send queue", n)
 		}
-	}()
+		return nil
+	}
 	select {
-	case r.sendCh <- segmentObject:
-	default:
-		r.logger.Printf("reach max send buffer")
+	case <-r.pipelineDone:
+		if n := r.dropped() - dropped; n > 0 {
+			return errors.Errorf("%d segments dropped while flushing", n)
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.Errorf("flush timeout, %d segments dropped in send queue, %d segments not acknowledged",
+			len(r.sendCh), atomic.LoadInt64(&r.inflight))
 	}
 }
 
-func (r *gRPCReporter) Close() {
+func (r *gRPCReporter) closeSendCh() {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	if r.sendChClosed {
+		return
+	}
+	r.sendChClosed = true
 	if r.sendCh != nil {
 		close(r.sendCh)
 	}
-	r.closeGRPCConn()
+	if r.flushed != nil {
+		close(r.flushed)
+	}
+}
+
+func (r *gRPCReporter) queueClosed() bool {
+	r.sendMu.RLock()
+	defer r.sendMu.RUnlock()
+	return r.sendChClosed
 }
 
 func (r *gRPCReporter) closeGRPCConn() {
-	if r.conn != nil {
-		if err := r.conn.Close(); err != nil {
-			r.logger.Print(err)
+	r.connCloseOnce.Do(func() {
+		if r.conn != nil {
+			if err := r.conn.Close(); err != nil {
+				r.logger.Print(err)
+			}
 		}
-	}
+	})
 }
 
 func (r *gRPCReporter) initSendPipeline() {
 	if r.traceClient == nil {
 		return
 	}
+	r.pipelineDone = make(chan struct{})
 	go func() {
+		defer close(r.pipelineDone)
+		if r.spool != nil {
+			defer r.spool.close()
+		}
+		bo := r.newBackoff()
 	StreamLoop:
 		for {
 			stream, err := r.traceClient.Collect(metadata.NewOutgoingContext(context.Background(), r.md))
 			if err != nil {
 				r.logger.Printf("open stream error %v", err)
-				time.Sleep(5 * time.Second)
+				if r.spool != nil {
+					// Keep the queued segments in spool while OAP server is unreachable
+					r.drainQueue()
+				}
+				if r.queueClosed() || r.conn != nil && r.conn.GetState() == connectivity.Shutdown {
+					// The reporter is flushed or closed, gives up reconnecting
+					r.drainQueue()
+					r.dropWindow()
+					r.closeGRPCConn()
+					break
+				}
+				r.wait(bo.next(), r.flushed)
 				continue StreamLoop
 			}
-			for s := range r.sendCh {
-				err = stream.Send(s)
-				if err != nil {
-					r.logger.Printf("send segment error %v", err)
-					r.closeStream(stream)
-					continue StreamLoop
+			openedAt := time.Now()
+			// The segments not acknowledged by the broken stream are sent first
+			if err = r.resendWindow(stream); err == nil {
+				err = r.replaySpool(stream)
+			}
+			for err == nil && !r.windowFull() && !r.streamExpired(openedAt) {
+				s, ok := <-r.sendCh
+				if ok {
+					atomic.AddInt64(&r.inflight, 1)
+				} else {
+					// The reporter is flushed
+					_ = r.ackWindow(stream)
+					r.dropWindow()
+					r.closeGRPCConn()
+					break StreamLoop
+				}
+				if err = r.send(stream, s); err == nil {
+					// The segments spooled when the send queue is full
+					err = r.replaySpool(stream)
 				}
 			}
-			r.closeStream(stream)
-			r.closeGRPCConn()
-			break
+			if err != nil {
+				r.logger.Printf("send segment error %v", err)
+				r.closeStream(stream)
+				r.windowFailed()
+				r.wait(bo.next(), r.flushed)
+				continue StreamLoop
+			}
+			// The retry window is acknowledged by closing the stream, and a new stream
+			// is opened to the backend picked by the load balance policy
+			if err = r.ackWindow(stream); err != nil {
+				r.windowFailed()
+				r.wait(bo.next(), r.flushed)
+				continue StreamLoop
+			}
+			bo.reset()
 		}
 	}()
 }
 
+// send sends the segment by stream, the segment is kept in the retry window
+// until the stream is acknowledged.
+func (r *gRPCReporter) send(stream agentv3.TraceSegmentReportService_CollectClient, s *agentv3.SegmentObject) error {
+	if r.retryWindowSize > 0 {
+		r.retryWindow = append(r.retryWindow, s)
+	}
+	err := stream.Send(s)
+	if r.retryWindowSize <= 0 {
+		if err != nil {
+			r.dropSegment(s)
+		}
+		atomic.AddInt64(&r.inflight, -1)
+	}
+	return err
+}
+
+// resendWindow sends the segments in the retry window by the new stream
+func (r *gRPCReporter) resendWindow(stream agentv3.TraceSegmentReportService_CollectClient) error {
+	pending := r.retryWindow
+	r.retryWindow = nil
+	for i, s := range pending {
+		if err := r.send(stream, s); err != nil {
+			r.retryWindow = append(r.retryWindow, pending[i+1:]...)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *gRPCReporter) windowFull() bool {
+	return r.retryWindowSize > 0 && len(r.retryWindow) >= r.retryWindowSize
+}
+
+// ackWindow closes the stream, and clears the retry window when OAP server
+// acknowledges the segments sent by the stream.
+func (r *gRPCReporter) ackWindow(stream agentv3.TraceSegmentReportService_CollectClient) error {
+	_, err := stream.CloseAndRecv()
+	if err != nil && err != io.EOF {
+		r.logger.Printf("send closing error %v", err)
+		return err
+	}
+	atomic.AddInt64(&r.inflight, -int64(len(r.retryWindow)))
+	r.retryWindow = nil
+	r.windowRetries = 0
+	return nil
+}
+
+// windowFailed counts the streams failed to deliver the retry window, the window
+// is dropped after maxWindowRetries failures, so the send queue is still drained
+// while OAP server keeps rejecting the segments.
+func (r *gRPCReporter) windowFailed() {
+	if len(r.retryWindow) == 0 {
+		r.windowRetries = 0
+		return
+	}
+	if r.windowRetries++; r.windowRetries < maxWindowRetries {
+		return
+	}
+	r.logger.Printf("%d segments dropped after %d retries", len(r.retryWindow), r.windowRetries)
+	r.dropWindow()
+}
+
+// dropWindow drops the segments in the retry window when the reporter is flushed
+func (r *gRPCReporter) dropWindow() {
+	for _, s := range r.retryWindow {
+		r.dropSegment(s)
+	}
+	atomic.AddInt64(&r.inflight, -int64(len(r.retryWindow)))
+	r.retryWindow = nil
+	r.windowRetries = 0
+}
+
+// dropSegment keeps the segment failed to send in spool, or counts it as dropped
+func (r *gRPCReporter) dropSegment(s *agentv3.SegmentObject) {
+	if r.spool != nil {
+		r.spoolSegment(s)
+		return
+	}
+	atomic.AddInt64(&r.sendDropped, 1)
+}
+
+func (r *gRPCReporter) spoolSegment(s *agentv3.SegmentObject) {
+	if err := r.spool.write(s); err != nil {
+		r.logger.Printf("spool segment error %v", err)
+	}
+}
+
+// drainQueue takes the queued segments without blocking, they are kept in spool
+// when it is set up, or counted as dropped.
+func (r *gRPCReporter) drainQueue() {
+	for {
+		select {
+		case s, ok := <-r.sendCh:
+			if !ok {
+				return
+			}
+			r.dropSegment(s)
+		default:
+			return
+		}
+	}
+}
+
+// replaySpool sends the spooled segments in order until the spool is empty
+func (r *gRPCReporter) replaySpool(stream agentv3.TraceSegmentReportService_CollectClient) error {
+	if r.spool == nil {
+		return nil
+	}
+	for !r.spool.empty() && !r.windowFull() {
+		s, err := r.spool.next()
+		if err != nil {
+			r.logger.Printf("read spool error %v", err)
+			continue
+		}
+		if s == nil {
+			return nil
+		}
+		r.spool.ack()
+		atomic.AddInt64(&r.inflight, 1)
+		if err = r.send(stream, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *gRPCReporter) closeStream(stream agentv3.TraceSegmentReportService_CollectClient) {
 	_, err := stream.CloseAndRecv()
 	if err != nil && err != io.EOF {
@@ -269,12 +605,16 @@ func (r *gRPCReporter) reportInstanceProperties() (err error) {
 			})
 		}
 	}
-	_, err = r.managementClient.ReportInstanceProperties(metadata.NewOutgoingContext(context.Background(), r.md), &managementv3.InstanceProperties{
+	commands, err := r.managementClient.ReportInstanceProperties(metadata.NewOutgoingContext(context.Background(), r.md), &managementv3.InstanceProperties{
 		Service:         r.service,
 		ServiceInstance: r.serviceInstance,
 		Properties:      props,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	r.dispatchCommands(commands)
+	return nil
 }
 
 func (r *gRPCReporter) check() {
@@ -283,30 +623,41 @@ func (r *gRPCReporter) check() {
 	}
 	go func() {
 		instancePropertiesSubmitted := false
+		bo := r.newBackoff()
 		for {
-			if r.conn.GetState() == connectivity.Shutdown {
+			state := r.conn.GetState()
+			if state == connectivity.Shutdown {
 				break
 			}
+			if state == connectivity.TransientFailure {
+				// No call until the connection recovers
+				r.wait(bo.next(), nil)
+				continue
+			}
 
 			if !instancePropertiesSubmitted {
 				err := r.reportInstanceProperties()
 				if err != nil {
 					r.logger.Printf("report serviceInstance properties error %v", err)
-					time.Sleep(r.checkInterval)
+					r.wait(bo.next(), nil)
 					continue
 				}
 				instancePropertiesSubmitted = true
 			}
 
-			_, err := r.managementClient.KeepAlive(metadata.NewOutgoingContext(context.Background(), r.md), &managementv3.InstancePingPkg{
+			commands, err := r.managementClient.KeepAlive(metadata.NewOutgoingContext(context.Background(), r.md), &managementv3.InstancePingPkg{
 				Service:         r.service,
 				ServiceInstance: r.serviceInstance,
 			})
 
 			if err != nil {
 				r.logger.Printf("send keep alive signal error %v", err)
+				r.wait(bo.next(), nil)
+				continue
 			}
-			time.Sleep(r.checkInterval)
+			bo.reset()
+			r.dispatchCommands(commands)
+			r.wait(r.checkInterval, nil)
 		}
 	}()
 }
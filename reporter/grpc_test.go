@@ -32,6 +32,7 @@ import (
 	managementv3 "github.com/SkyAPM/go2sky/reporter/grpc/management"
 	"github.com/SkyAPM/go2sky/reporter/grpc/management/mock_management"
 	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -108,6 +109,38 @@ func Test_e2e(t *testing.T) {
 	}
 }
 
+func TestGRPCReporter_CrossThreadRef(t *testing.T) {
+	reporter := createGRPCReporter()
+	reporter.sendCh = make(chan *v3.SegmentObject, 10)
+	tracer, err := go2sky.NewTracer(mockService, go2sky.WithReporter(reporter), go2sky.WithInstance(mockServiceInstance))
+	if err != nil {
+		t.Error(err)
+	}
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), go2sky.WithOperationName("root"))
+	if err != nil {
+		t.Error(err)
+	}
+	workerSpan, _, err := tracer.Continue(context.Background(), go2sky.Capture(ctx), go2sky.WithOperationName("worker"))
+	if err != nil {
+		t.Error(err)
+	}
+	workerSpan.End()
+	worker := <-reporter.sendCh
+	span.End()
+	root := <-reporter.sendCh
+	reporter.Close()
+	refs := worker.Spans[0].Refs
+	if len(refs) != 1 || refs[0].RefType != v3.RefType_CrossThread {
+		t.Fatal("cross thread reference is not reported")
+	}
+	if refs[0].ParentTraceSegmentId != root.TraceSegmentId || refs[0].ParentEndpoint != "root" {
+		t.Error("cross thread reference is wrong")
+	}
+	if len(root.Spans[0].Refs) != 0 {
+		t.Error("root segment should not have reference")
+	}
+}
+
 func TestGRPCReporter_Close(t *testing.T) {
 	reporter := createGRPCReporter()
 	reporter.sendCh = make(chan *v3.SegmentObject, 1)
@@ -126,6 +159,117 @@ func TestGRPCReporter_Close(t *testing.T) {
 	time.Sleep(time.Second)
 }
 
+func TestGRPCReporter_Flush(t *testing.T) {
+	reporter := createGRPCReporter()
+	reporter.sendCh = make(chan *v3.SegmentObject, 1)
+	reporter.sendCh <- &v3.SegmentObject{}
+	if err := reporter.Flush(context.Background()); err == nil {
+		t.Error("queued segment should be reported as dropped")
+	}
+
+	reporter = createGRPCReporter()
+	reporter.sendCh = make(chan *v3.SegmentObject, 1)
+	reporter.pipelineDone = make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := reporter.Flush(ctx); err == nil {
+		t.Error("flush should return error when ctx is done")
+	}
+	reporter.Close()
+}
+
+type fakeTraceClient struct {
+	streams []*fakeCollectClient
+}
+
+func (c *fakeTraceClient) Collect(ctx context.Context, opts ...grpc.CallOption) (v3.TraceSegmentReportService_CollectClient, error) {
+	stream := &fakeCollectClient{}
+	if len(c.streams) > 0 {
+		stream = c.streams[0]
+	}
+	c.streams = append(c.streams[1:], stream)
+	return stream, nil
+}
+
+func TestGRPCReporter_RetryWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		windowSize int
+		streams    []*fakeCollectClient
+		// segment ids received by each stream
+		want    string
+		dropped int64
+	}{
+		{"resend window", 10, []*fakeCollectClient{{limit: 2}, {}}, "[[0 1] [0 1 2 3 4]]", 0},
+		{"retry disabled", 0, []*fakeCollectClient{{limit: 2}, {}}, "[[0 1] [3 4]]", 1},
+		{"acknowledge full window", 2, []*fakeCollectClient{{}, {}, {}}, "[[0 1] [2 3] [4]]", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := createGRPCReporter()
+			reporter.retryWindowSize = tt.windowSize
+			reporter.backoffBase = time.Millisecond
+			streams := append([]*fakeCollectClient(nil), tt.streams...)
+			reporter.traceClient = &fakeTraceClient{streams: tt.streams}
+			reporter.sendCh = make(chan *v3.SegmentObject, 5)
+			for i := 0; i < 5; i++ {
+				reporter.sendCh <- &v3.SegmentObject{TraceSegmentId: fmt.Sprint(i)}
+			}
+			reporter.initSendPipeline()
+			if err := reporter.Flush(context.Background()); (err != nil) != (tt.dropped > 0) {
+				t.Errorf("flush error %v, want %d segments dropped", err, tt.dropped)
+			}
+			var got [][]string
+			for _, stream := range streams {
+				var ids []string
+				for _, s := range stream.sent {
+					ids = append(ids, s.TraceSegmentId)
+				}
+				got = append(got, ids)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("segments are sent as %v, want %s", got, tt.want)
+			}
+			if reporter.Stats().SendDropped != tt.dropped {
+				t.Errorf("dropped %d segments, want %d", reporter.Stats().SendDropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestGRPCReporter_AckFailure(t *testing.T) {
+	reporter := createGRPCReporter()
+	reporter.retryWindowSize = 2
+	reporter.backoffBase = time.Millisecond
+	client := &fakeTraceClient{}
+	for i := 0; i < 10; i++ {
+		client.streams = append(client.streams, &fakeCollectClient{closeErr: fmt.Errorf("unauthenticated")})
+	}
+	streams := append([]*fakeCollectClient(nil), client.streams...)
+	reporter.traceClient = client
+	reporter.sendCh = make(chan *v3.SegmentObject, 5)
+	for i := 0; i < 5; i++ {
+		reporter.sendCh <- &v3.SegmentObject{TraceSegmentId: fmt.Sprint(i)}
+	}
+	reporter.initSendPipeline()
+	if err := reporter.Flush(context.Background()); err == nil {
+		t.Error("flush should return error when segments are dropped")
+	}
+	if dropped := reporter.Stats().SendDropped; dropped != 5 {
+		t.Errorf("dropped %d segments, want 5", dropped)
+	}
+	// 2 full windows are sent by maxWindowRetries streams each, and the last segment by 1 stream
+	var used int
+	for _, stream := range streams {
+		if len(stream.sent) > 0 {
+			used++
+		}
+	}
+	if want := 2*maxWindowRetries + 1; used != want {
+		t.Errorf("%d streams are used, want %d", used, want)
+	}
+}
+
 func TestGRPCReporterOption(t *testing.T) {
 	// props
 	instanceProps := make(map[string]string)
@@ -0,0 +1,99 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+)
+
+const defaultQueueBlockTimeout = 10 * time.Millisecond
+
+// QueueFullPolicy decides the segment dropped when the send queue is full
+type QueueFullPolicy int
+
+const (
+	// QueueDropNewest drops the segment being reported
+	QueueDropNewest QueueFullPolicy = iota
+	// QueueDropOldest drops the oldest segment in the queue to make room for the new one
+	QueueDropOldest
+	// QueueBlock blocks the reporting goroutine until there is room in the queue,
+	// the segment is dropped when it is blocked longer than the timeout set by WithQueueBlockTimeout
+	QueueBlock
+)
+
+// WithQueueFullPolicy setup the policy when the send queue is full, QueueDropNewest by default.
+// The dropped segments are kept in spool when WithDiskSpool is set up.
+func WithQueueFullPolicy(policy QueueFullPolicy) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.queueFullPolicy = policy
+	}
+}
+
+// WithQueueBlockTimeout setup the max time to block the reporting goroutine by QueueBlock policy
+func WithQueueBlockTimeout(timeout time.Duration) GRPCReporterOption {
+	return func(r *gRPCReporter) {
+		r.queueBlockTimeout = timeout
+	}
+}
+
+// enqueue puts the segment into the send queue, the policy decides the segment dropped when the queue is full
+func (r *gRPCReporter) enqueue(s *agentv3.SegmentObject) {
+	select {
+	case r.sendCh <- s:
+		return
+	default:
+	}
+	switch r.queueFullPolicy {
+	case QueueDropOldest:
+		for {
+			select {
+			case old, ok := <-r.sendCh:
+				if ok {
+					r.queueDrop(old)
+				}
+			default:
+			}
+			select {
+			case r.sendCh <- s:
+				return
+			default:
+			}
+		}
+	case QueueBlock:
+		timer := time.NewTimer(r.queueBlockTimeout)
+		defer timer.Stop()
+		select {
+		case r.sendCh <- s:
+			return
+		case <-timer.C:
+		}
+	}
+	r.queueDrop(s)
+}
+
+func (r *gRPCReporter) queueDrop(s *agentv3.SegmentObject) {
+	if r.spool != nil {
+		r.spoolSegment(s)
+		return
+	}
+	atomic.AddInt64(&r.queueDropped, 1)
+	r.logger.Printf("reach max send buffer")
+}
@@ -0,0 +1,65 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+)
+
+func TestGRPCReporter_QueueFullPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  QueueFullPolicy
+		consume bool
+		want    string
+		dropped int64
+	}{
+		{"drop newest", QueueDropNewest, false, "1", 1},
+		{"drop oldest", QueueDropOldest, false, "2", 1},
+		{"block until timeout", QueueBlock, false, "1", 1},
+		{"block until dequeued", QueueBlock, true, "2", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := createGRPCReporter()
+			WithQueueFullPolicy(tt.policy)(reporter)
+			WithQueueBlockTimeout(10 * time.Millisecond)(reporter)
+			if tt.consume {
+				WithQueueBlockTimeout(time.Second)(reporter)
+			}
+			reporter.sendCh = make(chan *agentv3.SegmentObject, 1)
+			reporter.enqueue(&agentv3.SegmentObject{TraceSegmentId: "1"})
+			if tt.consume {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					<-reporter.sendCh
+				}()
+			}
+			reporter.enqueue(&agentv3.SegmentObject{TraceSegmentId: "2"})
+			if s := <-reporter.sendCh; s.TraceSegmentId != tt.want {
+				t.Errorf("segment %s is queued, want %s", s.TraceSegmentId, tt.want)
+			}
+			if dropped := reporter.Stats().QueueDropped; dropped != tt.dropped {
+				t.Errorf("dropped %d segments, want %d", dropped, tt.dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+const (
+	spoolFileSuffix  = ".spool"
+	maxSpoolFileSize = 4 << 20
+	// the spool is divided into several files, so that the oldest one is
+	// removed to make room when the spool is full
+	minSpoolFileNum = 4
+)
+
+type spoolFile struct {
+	seq     uint64
+	size    int64
+	modTime time.Time
+	// the number of segments written and read
+	count int64
+	read  int64
+}
+
+// diskSpool keeps the segments which cannot be sent to OAP server in a directory,
+// as length-delimited protobuf in the files named by sequence. The oldest files are
+// removed when the total size exceeds maxSize, and the files not written in maxAge expire.
+type diskSpool struct {
+	// the number of segments dropped, kept first for 64-bit alignment on 32-bit platforms
+	dropped int64
+
+	dir      string
+	maxSize  int64
+	maxAge   time.Duration
+	fileSize int64
+
+	mu      sync.Mutex
+	files   []*spoolFile // oldest first, the last one is being written when writer is not nil
+	size    int64
+	writer  *os.File
+	nextSeq uint64
+
+	reader     *bufio.Reader
+	readerFile *os.File
+	// the segment returned by next and not acked yet
+	pending *agentv3.SegmentObject
+}
+
+func newDiskSpool(dir string, maxSize int64, maxAge time.Duration) (*diskSpool, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("spool size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create spool directory")
+	}
+	s := &diskSpool{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		fileSize: maxSize / minSpoolFileNum,
+	}
+	if s.fileSize > maxSpoolFileSize {
+		s.fileSize = maxSpoolFileSize
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read spool directory")
+	}
+	// Continue the segments spooled before the process restarted
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, &spoolFile{
+			seq:     seq,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			count:   countSpooled(filepath.Join(dir, name)),
+		})
+		s.size += info.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].seq < s.files[j].seq })
+	if len(s.files) > 0 {
+		s.nextSeq = s.files[len(s.files)-1].seq + 1
+	}
+	return s, nil
+}
+
+func (s *diskSpool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolFileSuffix))
+}
+
+// empty returns true when there is no segment in the spool
+func (s *diskSpool) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files) == 0
+}
+
+// write appends the segment to the spool, the oldest files are removed to make room for it
+func (s *diskSpool) write(segment *agentv3.SegmentObject) error {
+	data, err := proto.Marshal(segment)
+	if err != nil {
+		return err
+	}
+	data = append(proto.EncodeVarint(uint64(len(data))), data...)
+	n := int64(len(data))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if s.writer != nil && s.files[len(s.files)-1].size+n > s.fileSize {
+		s.seal()
+	}
+	for s.size+n > s.maxSize && s.sealedNum() > 0 {
+		s.remove()
+	}
+	if s.size+n > s.maxSize {
+		atomic.AddInt64(&s.dropped, 1)
+		return errors.New("spool is full")
+	}
+	if s.writer == nil {
+		f, err := os.OpenFile(s.path(s.nextSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s.writer = f
+		s.files = append(s.files, &spoolFile{seq: s.nextSeq})
+		s.nextSeq++
+	}
+	f := s.files[len(s.files)-1]
+	written, err := s.writer.Write(data)
+	f.size += int64(written)
+	f.modTime = time.Now()
+	s.size += int64(written)
+	if err != nil {
+		// The partial segment is discarded when it is read
+		atomic.AddInt64(&s.dropped, 1)
+		s.seal()
+		return err
+	}
+	f.count++
+	return nil
+}
+
+// next returns the oldest segment in the spool, or nil when the spool is empty.
+// The same segment is returned until it is acked.
+func (s *diskSpool) next() (*agentv3.SegmentObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		return s.pending, nil
+	}
+	s.expire()
+	for len(s.files) > 0 {
+		if s.writer != nil && len(s.files) == 1 {
+			// Stop writing the file being read
+			s.seal()
+		}
+		if s.reader == nil {
+			f, err := os.Open(s.path(s.files[0].seq))
+			if err != nil {
+				s.remove()
+				return nil, err
+			}
+			s.readerFile = f
+			s.reader = bufio.NewReader(f)
+		}
+		segment, err := s.read()
+		if err == io.EOF {
+			s.remove()
+			continue
+		}
+		if err != nil {
+			// The rest of a corrupted file is dropped
+			s.remove()
+			return nil, err
+		}
+		s.files[0].read++
+		s.pending = segment
+		return segment, nil
+	}
+	return nil, nil
+}
+
+// ack removes the segment returned by next from the spool
+func (s *diskSpool) ack() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+}
+
+func (s *diskSpool) read() (*agentv3.SegmentObject, error) {
+	n, err := binary.ReadUvarint(s.reader)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(s.reader, data); err != nil {
+		return nil, errors.Wrap(err, "read spooled segment")
+	}
+	segment := &agentv3.SegmentObject{}
+	if err = proto.Unmarshal(data, segment); err != nil {
+		return nil, errors.Wrap(err, "decode spooled segment")
+	}
+	return segment, nil
+}
+
+// close stops writing the spool, the spooled segments are kept for the next process
+func (s *diskSpool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer != nil {
+		s.seal()
+	}
+	if s.readerFile != nil {
+		s.readerFile.Close()
+		s.readerFile = nil
+		s.reader = nil
+	}
+}
+
+func (s *diskSpool) sealedNum() int {
+	if s.writer != nil {
+		return len(s.files) - 1
+	}
+	return len(s.files)
+}
+
+func (s *diskSpool) seal() {
+	s.writer.Close()
+	s.writer = nil
+}
+
+// remove deletes the oldest file
+func (s *diskSpool) remove() {
+	if s.writer != nil && len(s.files) == 1 {
+		s.seal()
+	}
+	if s.readerFile != nil {
+		s.readerFile.Close()
+		s.readerFile = nil
+		s.reader = nil
+		s.pending = nil
+	}
+	f := s.files[0]
+	os.Remove(s.path(f.seq))
+	s.files = s.files[1:]
+	s.size -= f.size
+	atomic.AddInt64(&s.dropped, f.count-f.read)
+}
+
+// countSpooled returns the number of segments in the file spooled by the last process
+func countSpooled(path string) (count int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return count
+		}
+		if _, err = reader.Discard(int(n)); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+// expire removes the sealed files which are not written in maxAge
+func (s *diskSpool) expire() {
+	if s.maxAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-s.maxAge)
+	for s.sealedNum() > 0 && s.files[0].modTime.Before(deadline) {
+		s.remove()
+	}
+}
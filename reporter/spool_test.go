@@ -0,0 +1,205 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+	agentv3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
+	"google.golang.org/grpc"
+)
+
+type fakeCollectClient struct {
+	grpc.ClientStream
+	sent []*agentv3.SegmentObject
+	// Send fails after limit segments when limit is positive
+	limit int
+	// returned by CloseAndRecv
+	closeErr error
+}
+
+func (c *fakeCollectClient) Send(s *agentv3.SegmentObject) error {
+	if c.limit > 0 && len(c.sent) >= c.limit {
+		return fmt.Errorf("stream broken")
+	}
+	c.sent = append(c.sent, s)
+	return nil
+}
+
+func (c *fakeCollectClient) CloseAndRecv() (*common.Commands, error) {
+	return nil, c.closeErr
+}
+
+func newTestSpool(t *testing.T, maxSize int64, maxAge time.Duration) (*diskSpool, string) {
+	dir, err := ioutil.TempDir("", "go2sky-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool, err := newDiskSpool(dir, maxSize, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool, dir
+}
+
+func writeSegments(t *testing.T, spool *diskSpool, ids ...string) {
+	for _, id := range ids {
+		if err := spool.write(&agentv3.SegmentObject{TraceSegmentId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readSegments(t *testing.T, spool *diskSpool) (ids []string) {
+	for {
+		s, err := spool.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == nil {
+			return
+		}
+		ids = append(ids, s.TraceSegmentId)
+		spool.ack()
+	}
+}
+
+func TestDiskSpool(t *testing.T) {
+	spool, dir := newTestSpool(t, 1<<20, 0)
+	defer os.RemoveAll(dir)
+	writeSegments(t, spool, "1", "2")
+	s, err := spool.next()
+	if err != nil || s.TraceSegmentId != "1" {
+		t.Fatalf("next segment %v, error %v", s, err)
+	}
+	if s, _ = spool.next(); s.TraceSegmentId != "1" {
+		t.Error("segment not acked should be returned again")
+	}
+	spool.ack()
+	writeSegments(t, spool, "3")
+	if ids := readSegments(t, spool); fmt.Sprint(ids) != "[2 3]" {
+		t.Errorf("segments are replayed as %v", ids)
+	}
+	if !spool.empty() {
+		t.Error("spool should be empty")
+	}
+
+	writeSegments(t, spool, "4", "5")
+	spool.close()
+	spool, err = newDiskSpool(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spool.files[0].count != 2 {
+		t.Errorf("%d segments are counted in the spooled file, want 2", spool.files[0].count)
+	}
+	writeSegments(t, spool, "6")
+	if ids := readSegments(t, spool); fmt.Sprint(ids) != "[4 5 6]" {
+		t.Errorf("segments spooled by the last process are replayed as %v", ids)
+	}
+}
+
+func TestDiskSpool_Limit(t *testing.T) {
+	spool, dir := newTestSpool(t, 100, 0)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 50; i++ {
+		writeSegments(t, spool, fmt.Sprint(i))
+	}
+	if spool.size > 100 {
+		t.Errorf("spool size %d exceeds the limit", spool.size)
+	}
+	ids := readSegments(t, spool)
+	if len(ids) == 0 || len(ids) >= 50 || ids[len(ids)-1] != "49" {
+		t.Errorf("the oldest segments should be dropped, got %v", ids)
+	}
+	if spool.dropped != int64(50-len(ids)) {
+		t.Errorf("%d segments are counted as dropped, want %d", spool.dropped, 50-len(ids))
+	}
+
+	spool, dir = newTestSpool(t, 1<<20, 10*time.Millisecond)
+	defer os.RemoveAll(dir)
+	writeSegments(t, spool, "1")
+	spool.close()
+	time.Sleep(20 * time.Millisecond)
+	writeSegments(t, spool, "2")
+	if ids := readSegments(t, spool); fmt.Sprint(ids) != "[2]" {
+		t.Errorf("expired segments should be dropped, got %v", ids)
+	}
+	if spool.dropped != 1 {
+		t.Errorf("%d segments are counted as dropped, want 1", spool.dropped)
+	}
+}
+
+func TestDiskSpool_Corrupted(t *testing.T) {
+	spool, dir := newTestSpool(t, 1<<20, 0)
+	defer os.RemoveAll(dir)
+	writeSegments(t, spool, "1")
+	spool.close()
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 0, spoolFileSuffix)), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{10, 1})
+	f.Close()
+	spool, err = newDiskSpool(dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := spool.next(); s == nil || s.TraceSegmentId != "1" {
+		t.Fatal("the segment before corrupted data should be read")
+	}
+	spool.ack()
+	if _, err = spool.next(); err == nil {
+		t.Error("corrupted data should be reported")
+	}
+	if !spool.empty() {
+		t.Error("corrupted file should be removed")
+	}
+}
+
+func TestGRPCReporter_Spool(t *testing.T) {
+	spool, dir := newTestSpool(t, 1<<20, 0)
+	defer os.RemoveAll(dir)
+	reporter := createGRPCReporter()
+	reporter.spool = spool
+	reporter.sendCh = make(chan *agentv3.SegmentObject, 1)
+	for i := 0; i < 3; i++ {
+		reporter.sendCh <- &agentv3.SegmentObject{TraceSegmentId: fmt.Sprint(i)}
+		reporter.drainQueue()
+	}
+	stream := &fakeCollectClient{limit: 2}
+	if err := reporter.replaySpool(stream); err == nil {
+		t.Error("broken stream should stop replaying")
+	}
+	stream = &fakeCollectClient{}
+	if err := reporter.replaySpool(stream); err != nil {
+		t.Error(err)
+	}
+	if len(stream.sent) != 1 || stream.sent[0].TraceSegmentId != "2" {
+		t.Errorf("the segment failed to send should be replayed, got %v", stream.sent)
+	}
+	if reporter.Stats().SendDropped != 0 {
+		t.Error("spooled segments should not be dropped")
+	}
+}
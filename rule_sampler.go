@@ -0,0 +1,136 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"regexp"
+	"strings"
+)
+
+// OperationMatcher matches the operation name of spans
+type OperationMatcher interface {
+	Match(operation string) bool
+}
+
+type regexMatcher struct {
+	regex *regexp.Regexp
+}
+
+func (m *regexMatcher) Match(operation string) bool {
+	return m.regex.MatchString(operation)
+}
+
+// NewRegexMatcher creates an OperationMatcher by regular expression
+func NewRegexMatcher(expr string) (OperationMatcher, error) {
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &regexMatcher{regex: regex}, nil
+}
+
+// NewGlobMatcher creates an OperationMatcher by the path pattern of SkyWalking trace ignore plugin,
+// which supports '?' to match one character, '*' to match zero or more characters and '**' to match
+// zero or more directories. eg: /GET/api/**, /GET/user/*/detail
+func NewGlobMatcher(pattern string) (OperationMatcher, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	rr := []rune(pattern)
+	for i := 0; i < len(rr); i++ {
+		switch c := rr[i]; c {
+		case '*':
+			if i+1 < len(rr) && rr[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return NewRegexMatcher(expr.String())
+}
+
+// SamplingRule decides the sampling of the operations matched by Matcher
+type SamplingRule struct {
+	Matcher OperationMatcher
+	// Ignore the matched operations even they are sampled by upstream
+	Ignore bool
+	// Sampler of the matched operations when they are not ignored
+	Sampler Sampler
+}
+
+// RuleSampler samples the spans by the first rule matching the operation name,
+// the spans matching no rule are sampled by the default sampler.
+type RuleSampler struct {
+	rules          []SamplingRule
+	defaultSampler Sampler
+}
+
+// NewRuleSampler creates a RuleSampler, all spans matching no rule are sampled
+// when defaultSampler is nil.
+func NewRuleSampler(defaultSampler Sampler, rules ...SamplingRule) *RuleSampler {
+	if defaultSampler == nil {
+		defaultSampler = NewConstSampler(true)
+	}
+	return &RuleSampler{
+		rules:          rules,
+		defaultSampler: defaultSampler,
+	}
+}
+
+// IsSampled implements IsSampled() of Sampler.
+func (s *RuleSampler) IsSampled(params SamplingParameters) bool {
+	for _, rule := range s.rules {
+		if rule.Matcher == nil || !rule.Matcher.Match(params.OperationName) {
+			continue
+		}
+		if rule.Ignore {
+			return false
+		}
+		if rule.Sampler == nil {
+			return true
+		}
+		return rule.Sampler.IsSampled(params)
+	}
+	return s.defaultSampler.IsSampled(params)
+}
+
+// IsIgnored implements IsIgnored() of IgnoringSampler.
+func (s *RuleSampler) IsIgnored(params SamplingParameters) bool {
+	for _, rule := range s.rules {
+		if rule.Matcher == nil || !rule.Matcher.Match(params.OperationName) {
+			continue
+		}
+		if rule.Ignore {
+			return true
+		}
+		if is, ok := rule.Sampler.(IgnoringSampler); ok {
+			return is.IsIgnored(params)
+		}
+		return false
+	}
+	if is, ok := s.defaultSampler.(IgnoringSampler); ok {
+		return is.IsIgnored(params)
+	}
+	return false
+}
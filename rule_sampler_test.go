@@ -0,0 +1,161 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkyAPM/go2sky/propagation"
+)
+
+func TestNewGlobMatcher(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		operation string
+		want      bool
+	}{
+		{"/GET/healthz", "/GET/healthz", true},
+		{"/GET/healthz", "/GET/healthz/deep", false},
+		{"/GET/api/*", "/GET/api/user", true},
+		{"/GET/api/*", "/GET/api/user/1", false},
+		{"/GET/api/**", "/GET/api/user/1", true},
+		{"/GET/user/?", "/GET/user/1", true},
+		{"/GET/user/?", "/GET/user/12", false},
+		{"/GET/a.b", "/GET/axb", false},
+		{"/GET/用户/*", "/GET/用户/1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.operation, func(t *testing.T) {
+			m, err := NewGlobMatcher(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.operation); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRegexMatcher(t *testing.T) {
+	if _, err := NewRegexMatcher("("); err == nil {
+		t.Error("invalid regular expression should return error")
+	}
+	m, err := NewRegexMatcher("^/(GET|HEAD)/metrics$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match("/HEAD/metrics") || m.Match("/POST/metrics") {
+		t.Error("regex matcher is wrong")
+	}
+}
+
+func TestRuleSampler_IsSampled(t *testing.T) {
+	healthz, _ := NewGlobMatcher("/GET/healthz")
+	checkout, _ := NewGlobMatcher("/POST/checkout")
+	sampler := NewRuleSampler(NewConstSampler(false),
+		SamplingRule{Matcher: healthz, Ignore: true},
+		SamplingRule{Matcher: checkout, Sampler: NewConstSampler(true)},
+	)
+	tests := []struct {
+		params SamplingParameters
+		want   bool
+	}{
+		{SamplingParameters{OperationName: "/GET/healthz"}, false},
+		{SamplingParameters{OperationName: "/GET/healthz", ParentContext: &propagation.SpanContext{Sample: 1}}, false},
+		{SamplingParameters{OperationName: "/POST/checkout"}, true},
+		{SamplingParameters{OperationName: "/GET/other"}, false},
+		{SamplingParameters{OperationName: "/GET/other", ParentContext: &propagation.SpanContext{Sample: 1}}, true},
+	}
+	for _, tt := range tests {
+		if got := sampler.IsSampled(tt.params); got != tt.want {
+			t.Errorf("IsSampled(%s) = %v, want %v", tt.params.OperationName, got, tt.want)
+		}
+	}
+}
+
+func TestRuleSampler_IgnoreEntrySpan(t *testing.T) {
+	healthz, _ := NewGlobMatcher("/GET/healthz")
+	sampler := NewRuleSampler(nil, SamplingRule{Matcher: healthz, Ignore: true})
+	tracer, _ := NewTracer("service", WithReporter(&mockRegisterReporter{}), WithSamplerInstance(sampler))
+	span, _, err := tracer.CreateEntrySpan(context.Background(), "/GET/healthz", func() (string, error) {
+		return header, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := span.(*NoopSpan); !ok {
+		t.Error("ignored operation should create noop span")
+	}
+}
+
+type traceIDRecorder struct {
+	*RuleSampler
+	ignoredTraceIDs []string
+}
+
+func (s *traceIDRecorder) IsIgnored(params SamplingParameters) bool {
+	s.ignoredTraceIDs = append(s.ignoredTraceIDs, params.TraceID)
+	return s.RuleSampler.IsIgnored(params)
+}
+
+func TestRuleSampler_IgnoreWithoutTraceID(t *testing.T) {
+	healthz, _ := NewGlobMatcher("/GET/healthz")
+	sampler := &traceIDRecorder{RuleSampler: NewRuleSampler(nil, SamplingRule{Matcher: healthz, Ignore: true})}
+	tracer, _ := NewTracer("service", WithReporter(&mockRegisterReporter{}), WithSamplerInstance(sampler))
+	span, _, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("/GET/healthz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := span.(*NoopSpan); !ok {
+		t.Error("ignored operation should create noop span")
+	}
+	if len(sampler.ignoredTraceIDs) != 1 || sampler.ignoredTraceIDs[0] != "" {
+		t.Errorf("trace id should not be generated before the ignore check, got %q", sampler.ignoredTraceIDs)
+	}
+}
+
+func TestRuleSampler_TailRetention(t *testing.T) {
+	healthz, _ := NewGlobMatcher("/GET/healthz")
+	reporter := &mockDispatcherReporter{}
+	tracer, _ := NewTracer("service", WithReporter(reporter), WithTailRetention(time.Millisecond),
+		WithSamplerInstance(NewRuleSampler(NewConstSampler(false), SamplingRule{Matcher: healthz, Ignore: true})))
+	reporter.handlers[CommandConfigurationDiscovery](&ConfigurationDiscoveryCommand{UUID: "1", Config: map[string]string{
+		ConfigTraceIgnorePath: "/GET/static/**",
+	}})
+	for _, operation := range []string{"/GET/healthz", "/GET/static/js/app.js", "/GET/api"} {
+		span, _, err := tracer.CreateLocalSpan(context.Background(), WithOperationName(operation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ignored := span.(*NoopSpan)
+		if want := operation != "/GET/api"; ignored != want {
+			t.Errorf("%s ignored %v, want %v", operation, ignored, want)
+		}
+		span.Error(time.Now(), "failed")
+		span.End()
+	}
+	if err := tracer.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if len(reporter.Spans) != 1 || reporter.Spans[0].OperationName() != "/GET/api" {
+		t.Errorf("only the error segment not ignored should be retained, got %d spans", len(reporter.Spans))
+	}
+}
@@ -18,12 +18,42 @@
 package go2sky
 
 import (
+	"hash/fnv"
 	"math/rand"
+	"sync"
 	"time"
+
+	"github.com/SkyAPM/go2sky/propagation"
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
 )
 
+const rateLimitingWindow = 3 * time.Second
+
+// Sampler decides whether a new segment is traced, it is called when the span
+// has no parent span in the process. The span is ignored as NoopSpan when it
+// is not sampled. Otherwise, the segment is reported when the upstream context
+// is absent or sampled.
 type Sampler interface {
-	IsSampled(operation string) (sampled bool)
+	IsSampled(params SamplingParameters) (sampled bool)
+}
+
+// IgnoringSampler is implemented by the Sampler which ignores some operations.
+// The ignored span is not recorded even the tail retention is enabled, while the
+// span which is just not sampled is still recorded by the tail retention.
+// IsIgnored is called before IsSampled, the TraceID of params is empty when the
+// trace starts in the service, since it is generated for the trace not ignored.
+type IgnoringSampler interface {
+	IsIgnored(params SamplingParameters) (ignored bool)
+}
+
+// SamplingParameters are the parameters of sampling decision
+type SamplingParameters struct {
+	OperationName string
+	SpanType      SpanType
+	// ParentContext is the context of upstream, it is nil when the trace starts in the service
+	ParentContext *propagation.SpanContext
+	TraceID       string
+	Tags          []*common.KeyStringValuePair
 }
 
 type ConstSampler struct {
@@ -39,7 +69,11 @@ func NewConstSampler(sample bool) *ConstSampler {
 }
 
 // IsSampled implements IsSampled() of Sampler.
-func (s *ConstSampler) IsSampled(operation string) bool {
+// The decision of upstream is honored.
+func (s *ConstSampler) IsSampled(params SamplingParameters) bool {
+	if params.ParentContext != nil {
+		return true
+	}
 	return s.decision
 }
 
@@ -50,7 +84,11 @@ type RandomSampler struct {
 }
 
 // IsSampled implements IsSampled() of Sampler.
-func (s *RandomSampler) IsSampled(operation string) bool {
+// The decision of upstream is honored.
+func (s *RandomSampler) IsSampled(params SamplingParameters) bool {
+	if params.ParentContext != nil {
+		return true
+	}
 	return s.threshold >= s.rand.Intn(100)
 }
 
@@ -66,3 +104,95 @@ func NewRandomSampler(samplingRate float64) *RandomSampler {
 	s.init()
 	return s
 }
+
+// RateLimitingSampler samples at most n segments per 3 seconds,
+// which works as the sample_n_per_3_secs of SkyWalking Java agent.
+type RateLimitingSampler struct {
+	samplesPer3Secs int32
+	// mu guards the window and its count, which are reset together
+	mu      sync.Mutex
+	sampled int32
+	// unix nanoseconds of the current window
+	windowStart int64
+}
+
+// NewRateLimitingSampler creates a RateLimitingSampler, all segments are
+// sampled when samplesPer3Secs is not positive.
+func NewRateLimitingSampler(samplesPer3Secs int) *RateLimitingSampler {
+	return &RateLimitingSampler{
+		samplesPer3Secs: int32(samplesPer3Secs),
+		windowStart:     time.Now().UnixNano(),
+	}
+}
+
+// IsSampled implements IsSampled() of Sampler.
+// The decision of upstream is honored.
+func (s *RateLimitingSampler) IsSampled(params SamplingParameters) bool {
+	if params.ParentContext != nil || s.samplesPer3Secs <= 0 {
+		return true
+	}
+	now := time.Now().UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now-s.windowStart >= int64(rateLimitingWindow) {
+		// Refill the tokens of the new window
+		s.windowStart = now
+		s.sampled = 0
+	}
+	if s.sampled >= s.samplesPer3Secs {
+		return false
+	}
+	s.sampled++
+	return true
+}
+
+// TraceIDRatioSampler samples the traces by the hash of trace id, so the services
+// sharing the same ratio make the same decision for a trace. It is safe for
+// concurrent use.
+type TraceIDRatioSampler struct {
+	samplingRate float64
+	// compared with the 53 bits hash of trace id
+	threshold uint64
+}
+
+// NewTraceIDRatioSampler creates a TraceIDRatioSampler, samplingRate is in [0, 1].
+func NewTraceIDRatioSampler(samplingRate float64) *TraceIDRatioSampler {
+	if samplingRate < 0 {
+		samplingRate = 0
+	} else if samplingRate > 1 {
+		samplingRate = 1
+	}
+	return &TraceIDRatioSampler{
+		samplingRate: samplingRate,
+		threshold:    rateThreshold(samplingRate),
+	}
+}
+
+// IsSampled implements IsSampled() of Sampler.
+// The decision of upstream is honored.
+func (s *TraceIDRatioSampler) IsSampled(params SamplingParameters) bool {
+	if params.ParentContext != nil {
+		return true
+	}
+	return traceIDHash(params.TraceID) < s.threshold
+}
+
+// traceIDHash returns the 53 bits hash of trace id
+func traceIDHash(traceID string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(traceID))
+	// Mix the bits by the finalizer of murmur3, the high bits of fnv are not
+	// well distributed for the trace ids sharing the same prefix
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x >> 11
+}
+
+// rateThreshold returns the threshold of sampling rate compared with traceIDHash
+func rateThreshold(samplingRate float64) uint64 {
+	return uint64(samplingRate * (1 << 53))
+}
@@ -18,18 +18,24 @@
 package go2sky
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/SkyAPM/go2sky/propagation"
 )
 
 func TestConstSampler_IsSampled(t *testing.T) {
 	sampler := NewConstSampler(true)
-	operationName := "op"
-	sampled := sampler.IsSampled(operationName)
+	params := SamplingParameters{OperationName: "op"}
+	sampled := sampler.IsSampled(params)
 	if sampled != true {
 		t.Errorf("const sampler should be sampled")
 	}
 	samplerNegative := NewConstSampler(false)
-	sampledNegative := samplerNegative.IsSampled(operationName)
+	sampledNegative := samplerNegative.IsSampled(params)
 	if sampledNegative != false {
 		t.Errorf("const sampler should not be sampled")
 	}
@@ -39,9 +45,176 @@ func TestRandomSampler_IsSampled(t *testing.T) {
 	randomSampler := NewRandomSampler(0.5)
 	//just for test case
 	randomSampler.threshold = 100
-	operationName := "op"
-	sampled := randomSampler.IsSampled(operationName)
+	params := SamplingParameters{OperationName: "op"}
+	sampled := randomSampler.IsSampled(params)
 	if sampled != true {
 		t.Errorf("const sampler should be sampled")
 	}
 }
+
+func TestRateLimitingSampler_IsSampled(t *testing.T) {
+	sampler := NewRateLimitingSampler(2)
+	params := SamplingParameters{OperationName: "op"}
+	for i := 0; i < 2; i++ {
+		if !sampler.IsSampled(params) {
+			t.Errorf("rate limiting sampler should be sampled")
+		}
+	}
+	if sampler.IsSampled(params) {
+		t.Errorf("rate limiting sampler should not be sampled after the limit")
+	}
+	// just for test case, expire the current window
+	sampler.windowStart -= int64(rateLimitingWindow)
+	if !sampler.IsSampled(params) {
+		t.Errorf("rate limiting sampler should be sampled in the new window")
+	}
+	unlimited := NewRateLimitingSampler(0)
+	if !unlimited.IsSampled(params) {
+		t.Errorf("rate limiting sampler without limit should be sampled")
+	}
+}
+
+func TestRateLimitingSampler_Concurrent(t *testing.T) {
+	sampler := NewRateLimitingSampler(100)
+	for window := 0; window < 2; window++ {
+		var sampled int32
+		wg := sync.WaitGroup{}
+		wg.Add(10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if sampler.IsSampled(SamplingParameters{OperationName: "op"}) {
+						atomic.AddInt32(&sampled, 1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if sampled != 100 {
+			t.Errorf("expect 100 sampled in window %d, actual %d", window, sampled)
+		}
+		// The goroutines race on the reset of the expired window
+		sampler.mu.Lock()
+		sampler.windowStart -= int64(rateLimitingWindow)
+		sampler.mu.Unlock()
+	}
+}
+
+func TestSampler_HonorParentContext(t *testing.T) {
+	params := SamplingParameters{
+		OperationName: "op",
+		SpanType:      SpanTypeEntry,
+		ParentContext: &propagation.SpanContext{Sample: 1},
+	}
+	rateLimitingSampler := NewRateLimitingSampler(1)
+	rateLimitingSampler.IsSampled(SamplingParameters{OperationName: "op"})
+	samplers := []Sampler{NewConstSampler(false), NewRandomSampler(0), rateLimitingSampler}
+	for _, sampler := range samplers {
+		if !sampler.IsSampled(params) {
+			t.Errorf("%T should honor the decision of upstream", sampler)
+		}
+	}
+}
+
+type recordSampler struct {
+	params []SamplingParameters
+}
+
+func (s *recordSampler) IsSampled(params SamplingParameters) bool {
+	s.params = append(s.params, params)
+	return params.SpanType == SpanTypeEntry
+}
+
+func TestTracer_SamplingParameters(t *testing.T) {
+	sampler := &recordSampler{}
+	tracer, _ := NewTracer("service", WithReporter(&mockRegisterReporter{}), WithSamplerInstance(sampler))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("local"), WithTag(TagURL, "/local"))
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := span.(*NoopSpan); !ok {
+		t.Error("local span should not be sampled")
+	}
+	if _, _, err = tracer.CreateLocalSpan(ctx); err != nil {
+		t.Error(err)
+	}
+	span, ctx, err = tracer.CreateEntrySpan(context.Background(), "entry", func() (string, error) {
+		return header, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := span.(*NoopSpan); ok {
+		t.Error("entry span should be sampled")
+	}
+	if len(sampler.params) != 2 {
+		t.Fatalf("sampler should be called for the new traces only, actual %d", len(sampler.params))
+	}
+	local, entry := sampler.params[0], sampler.params[1]
+	if local.OperationName != "local" || local.SpanType != SpanTypeLocal || local.ParentContext != nil ||
+		local.TraceID == "" || len(local.Tags) != 1 {
+		t.Errorf("parameters of local span are wrong %+v", local)
+	}
+	if entry.ParentContext == nil || entry.TraceID != traceID || entry.SpanType != SpanTypeEntry {
+		t.Errorf("parameters of entry span are wrong %+v", entry)
+	}
+	if TraceID(ctx) != entry.TraceID {
+		t.Error("trace id of sampling parameters is not used")
+	}
+}
+
+func TestTraceIDRatioSampler_IsSampled(t *testing.T) {
+	sampler := NewTraceIDRatioSampler(0.5)
+	another := NewTraceIDRatioSampler(0.5)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		params := SamplingParameters{TraceID: fmt.Sprintf("%d.%d.%d", i, i*7, i*13)}
+		decision := sampler.IsSampled(params)
+		if decision != another.IsSampled(params) {
+			t.Fatalf("decision of trace %s should be consistent", params.TraceID)
+		}
+		if decision {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("sampled %d of 1000 traces with rate 0.5", sampled)
+	}
+	params := SamplingParameters{TraceID: "1f2d4bf47bf711eab794acde48001122"}
+	if NewTraceIDRatioSampler(0).IsSampled(params) {
+		t.Error("trace should not be sampled with rate 0")
+	}
+	if !NewTraceIDRatioSampler(1).IsSampled(params) {
+		t.Error("trace should be sampled with rate 1")
+	}
+}
+
+func TestTracer_ForceSample(t *testing.T) {
+	reporter := &mockRegisterReporter{}
+	tracer, _ := NewTracer("service", WithReporter(reporter), WithSampler(0))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithForceSample())
+	if err != nil {
+		t.Error(err)
+	}
+	if !IsSampled(ctx) {
+		t.Error("span should be force sampled")
+	}
+	span.End()
+	reporter.wait()
+
+	scx := propagation.SpanContext{}
+	if err = scx.DecodeSW8(header); err != nil {
+		t.Fatal(err)
+	}
+	scx.Sample = 0
+	_, ctx, err = tracer.CreateEntrySpan(context.Background(), "entry", func() (string, error) {
+		return scx.EncodeSW8(), nil
+	}, WithForceSample())
+	if err != nil {
+		t.Error(err)
+	}
+	if !IsSampled(ctx) {
+		t.Error("force sample should override the decision of upstream")
+	}
+}
@@ -29,7 +29,7 @@ import (
 
 func newSegmentSpan(defaultSpan *defaultSpan, parentSpan segmentSpan) (s segmentSpan, err error) {
 	ssi := &segmentSpanImpl{
-		defaultSpan: *defaultSpan,
+		defaultSpan: defaultSpan,
 	}
 	err = ssi.createSegmentContext(parentSpan)
 	if err != nil {
@@ -55,10 +55,29 @@ type SegmentContext struct {
 	SpanID          int32
 	ParentSpanID    int32
 	ParentSegmentID string
+	CrossThreadRef  *ContextSnapshot // the span in the same process which the segment continues from
+	SkipAnalysis    bool             // the segment is not analyzed by OAP server
+	sampled         bool
 	collect         chan<- ReportedSpan
 	refNum          *int32
 	spanIDGenerator *int32
-	FirstSpan       Span `json:"-"`
+	// the spans reserved in the segment, which are checked by the span limit
+	spanNum        *int32
+	droppedSpanNum *int32
+	FirstSpan      Span `json:"-"`
+}
+
+// IsSizeLimited returns true when spans of the segment are dropped by the span limit
+func (c *SegmentContext) IsSizeLimited() bool {
+	return c.DroppedSpanNum() > 0
+}
+
+// DroppedSpanNum returns the number of spans dropped by the span limit of the segment
+func (c *SegmentContext) DroppedSpanNum() int32 {
+	if c.droppedSpanNum == nil {
+		return 0
+	}
+	return atomic.LoadInt32(c.droppedSpanNum)
 }
 
 // ReportedSpan is accessed by Reporter to load reported data
@@ -81,16 +100,28 @@ type segmentSpan interface {
 	Span
 	context() SegmentContext
 	segmentRegister() bool
+	spanLimitExceeded(limit int32) bool
 }
 
 type segmentSpanImpl struct {
-	defaultSpan
+	*defaultSpan
 	SegmentContext
 }
 
 // For Span
 func (s *segmentSpanImpl) End() {
-	s.defaultSpan.End()
+	if s.defaultSpan.end() {
+		s.submit()
+	}
+}
+
+func (s *segmentSpanImpl) AsyncFinish() {
+	if s.defaultSpan.asyncFinish() {
+		s.submit()
+	}
+}
+
+func (s *segmentSpanImpl) submit() {
 	go func() {
 		s.Context().collect <- s
 	}()
@@ -111,14 +142,18 @@ func (s *segmentSpanImpl) StartTime() int64 {
 }
 
 func (s *segmentSpanImpl) EndTime() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return tool.Millisecond(s.defaultSpan.EndTime)
 }
 
 func (s *segmentSpanImpl) OperationName() string {
-	return s.defaultSpan.OperationName
+	return s.defaultSpan.GetOperationName()
 }
 
 func (s *segmentSpanImpl) Peer() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.Peer
 }
 
@@ -127,22 +162,32 @@ func (s *segmentSpanImpl) SpanType() v3.SpanType {
 }
 
 func (s *segmentSpanImpl) SpanLayer() v3.SpanLayer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.Layer
 }
 
 func (s *segmentSpanImpl) IsError() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.IsError
 }
 
 func (s *segmentSpanImpl) Tags() []*common.KeyStringValuePair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.Tags
 }
 
 func (s *segmentSpanImpl) Logs() []*v3.Log {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.Logs
 }
 
 func (s *segmentSpanImpl) ComponentID() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.defaultSpan.ComponentID
 }
 
@@ -162,17 +207,46 @@ func (s *segmentSpanImpl) segmentRegister() bool {
 	}
 }
 
+// spanLimitExceeded reserves a span in the unfinished segment, it returns true
+// and counts the dropped span when the segment holds limit spans.
+func (s *segmentSpanImpl) spanLimitExceeded(limit int32) bool {
+	if limit <= 0 || atomic.LoadInt32(s.Context().refNum) < 0 {
+		return false
+	}
+	if atomic.AddInt32(s.Context().spanNum, 1) <= limit {
+		return false
+	}
+	atomic.AddInt32(s.Context().droppedSpanNum, 1)
+	return true
+}
+
 func (s *segmentSpanImpl) createSegmentContext(parent segmentSpan) (err error) {
 	if parent == nil {
-		s.SegmentContext = SegmentContext{}
+		s.SegmentContext = SegmentContext{
+			SkipAnalysis: s.defaultSpan.skipAnalysis,
+			sampled:      true,
+		}
+		if s.defaultSpan.snapshot != nil && s.defaultSpan.snapshot.SkipAnalysis {
+			s.SkipAnalysis = true
+		}
 		if len(s.defaultSpan.Refs) > 0 {
 			s.TraceID = s.defaultSpan.Refs[0].TraceID
+			// Honor the sampling decision of upstream
+			s.sampled = s.defaultSpan.Refs[0].Sample != 0 || s.defaultSpan.forceSample
+		} else if s.defaultSpan.snapshot != nil {
+			s.TraceID = s.defaultSpan.snapshot.TraceID
+			s.sampled = s.defaultSpan.snapshot.sampled
+		} else if s.defaultSpan.traceID != "" {
+			s.TraceID = s.defaultSpan.traceID
 		} else {
 			s.TraceID, err = idgen.GenerateGlobalID()
 			if err != nil {
 				return err
 			}
 		}
+		if s.defaultSpan.unsampled {
+			s.sampled = false
+		}
 	} else {
 		s.SegmentContext = parent.context()
 		s.ParentSegmentID = s.SegmentID
@@ -193,7 +267,18 @@ type rootSegmentSpan struct {
 }
 
 func (rs *rootSegmentSpan) End() {
-	rs.defaultSpan.End()
+	if rs.defaultSpan.end() {
+		rs.done()
+	}
+}
+
+func (rs *rootSegmentSpan) AsyncFinish() {
+	if rs.defaultSpan.asyncFinish() {
+		rs.done()
+	}
+}
+
+func (rs *rootSegmentSpan) done() {
 	go func() {
 		rs.doneCh <- atomic.SwapInt32(rs.Context().refNum, -1)
 	}()
@@ -206,8 +291,17 @@ func (rs *rootSegmentSpan) createRootSegmentContext(parent segmentSpan) (err err
 	}
 	i := int32(0)
 	rs.spanIDGenerator = &i
+	spanNum := int32(1)
+	rs.spanNum = &spanNum
+	var dropped int32
+	rs.droppedSpanNum = &dropped
 	rs.SpanID = i
 	rs.ParentSpanID = -1
+	if parent != nil {
+		rs.CrossThreadRef = newContextSnapshot(parent)
+	} else {
+		rs.CrossThreadRef = rs.defaultSpan.snapshot
+	}
 	return
 }
 
@@ -222,6 +316,7 @@ func newSegmentRoot(segmentSpan *segmentSpanImpl) *rootSegmentSpan {
 	s.notify = ch
 	s.segment = make([]ReportedSpan, 0, 10)
 	s.doneCh = make(chan int32)
+	s.tracer.segmentOpened()
 	go func() {
 		total := -1
 		defer close(ch)
@@ -237,7 +332,7 @@ func newSegmentRoot(segmentSpan *segmentSpanImpl) *rootSegmentSpan {
 				break
 			}
 		}
-		s.tracer.reporter.Send(append(s.segment, s))
+		s.tracer.reportSegment(append(s.segment, s))
 	}()
 	return s
 }
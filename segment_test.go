@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SkyAPM/go2sky/propagation"
 	v3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
 )
 
@@ -104,6 +105,146 @@ func TestAsyncMultipleSegments(t *testing.T) {
 	}
 }
 
+func TestAsyncSpan(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(1)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("segmentTest", WithReporter(&mr))
+	span, ctx, _ := tracer.CreateEntrySpan(context.Background(), "entry", MockExtractor)
+	eSpan, _ := tracer.CreateExitSpan(ctx, "exit", "localhost:8080", MockInjector)
+	span.PrepareAsync()
+	eSpan.PrepareAsync()
+	eSpan.End()
+	span.End()
+	finishWg := &sync.WaitGroup{}
+	finishWg.Add(2)
+	go func() {
+		eSpan.Tag(TagStatusCode, "200")
+		eSpan.AsyncFinish()
+		finishWg.Done()
+	}()
+	go func() {
+		span.Tag(TagStatusCode, "200")
+		span.AsyncFinish()
+		finishWg.Done()
+	}()
+	finishWg.Wait()
+	reportWg.Wait()
+	if err := mr.Verify(2); err != nil {
+		t.Error(err)
+	}
+	for _, s := range mr.Message[0] {
+		if len(s.Tags()) != 1 {
+			t.Errorf("async span %s is reported before finished", s.OperationName())
+		}
+	}
+}
+
+func TestSpanLimitPerSegment(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(1)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("segmentTest", WithReporter(&mr), WithSpanLimitPerSegment(3))
+	span, ctx, _ := tracer.CreateEntrySpan(context.Background(), "entry", MockExtractor)
+	for i := 0; i < 5; i++ {
+		eSpan, _ := tracer.CreateExitSpan(ctx, "exit", "localhost:8080", MockInjector)
+		if _, ok := eSpan.(*NoopSpan); ok != (i >= 2) {
+			t.Errorf("span %d exceeding the limit should be noop span", i)
+		}
+		eSpan.End()
+	}
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(3); err != nil {
+		t.Error(err)
+	}
+	rootCtx := mr.Message[0][2].Context()
+	if !rootCtx.IsSizeLimited() {
+		t.Error("segment should be size limited")
+	}
+	if rootCtx.DroppedSpanNum() != 3 {
+		t.Errorf("expect 3 dropped spans, actual %d", rootCtx.DroppedSpanNum())
+	}
+}
+
+func TestSpanLimitPerSegment_Inject(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(1)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("segmentTest", WithReporter(&mr), WithSpanLimitPerSegment(1))
+	span, ctx, _ := tracer.CreateEntrySpan(context.Background(), "entry", MockExtractor)
+	local, localCtx, _ := tracer.CreateLocalSpan(ctx)
+	if _, ok := local.(*NoopSpan); !ok {
+		t.Fatal("span exceeding the limit should be noop span")
+	}
+	for _, c := range []context.Context{ctx, localCtx} {
+		var header string
+		eSpan, err := tracer.CreateExitSpan(c, "exit", "localhost:8080", func(h string) error {
+			header = h
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := eSpan.(*NoopSpan); !ok {
+			t.Error("span exceeding the limit should be noop span")
+		}
+		sc := &propagation.SpanContext{}
+		if err := sc.DecodeSW8(header); err != nil {
+			t.Fatalf("context of the truncated segment should be injected: %v", err)
+		}
+		segment := span.(ReportedSpan).Context()
+		if sc.TraceID != segment.TraceID || sc.ParentSegmentID != segment.SegmentID ||
+			sc.ParentSpanID != segment.SpanID || sc.Sample != 1 {
+			t.Errorf("injected context %+v does not match the entry span", sc)
+		}
+		eSpan.End()
+	}
+	local.End()
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSpanLimitPerSegment_Concurrent(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(1)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("segmentTest", WithReporter(&mr), WithSpanLimitPerSegment(10))
+	span, ctx, _ := tracer.CreateLocalSpan(context.Background())
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(50)
+	for i := 0; i < 50; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			subSpan, _, _ := tracer.CreateLocalSpan(ctx)
+			subSpan.End()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(10); err != nil {
+		t.Error(err)
+	}
+	if dropped := mr.Message[0][9].Context().DroppedSpanNum(); dropped != 41 {
+		t.Errorf("expect 41 dropped spans, actual %d", dropped)
+	}
+}
+
 func TestReportedSpan(t *testing.T) {
 	reportWg := &sync.WaitGroup{}
 	reportWg.Add(1)
@@ -0,0 +1,59 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import "context"
+
+// ContextSnapshot is a reference to a span, which is used by Tracer.Continue
+// to link the segment created in another goroutine.
+type ContextSnapshot struct {
+	TraceID        string
+	SegmentID      string
+	SpanID         int32
+	ParentEndpoint string
+	SkipAnalysis   bool
+	sampled        bool
+}
+
+// Capture takes a snapshot of the active span in ctx.
+// It returns nil when there is no active span or the span is ignored.
+func Capture(ctx context.Context) *ContextSnapshot {
+	if ctx == nil {
+		return nil
+	}
+	span, ok := ctx.Value(ctxKeyInstance).(segmentSpan)
+	if !ok {
+		return nil
+	}
+	return newContextSnapshot(span)
+}
+
+func newContextSnapshot(span segmentSpan) *ContextSnapshot {
+	sc := span.context()
+	snapshot := &ContextSnapshot{
+		TraceID:      sc.TraceID,
+		SegmentID:    sc.SegmentID,
+		SpanID:       sc.SpanID,
+		SkipAnalysis: sc.SkipAnalysis,
+		sampled:      sc.sampled,
+	}
+	if sc.FirstSpan != nil {
+		snapshot.ParentEndpoint = sc.FirstSpan.GetOperationName()
+	}
+	return snapshot
+}
@@ -0,0 +1,98 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	if Capture(context.Background()) != nil {
+		t.Error("snapshot should be nil without active span")
+	}
+	tracer, _ := NewTracer("service")
+	_, ctx, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if Capture(ctx) != nil {
+		t.Error("snapshot should be nil with noop span")
+	}
+}
+
+func TestTracer_Continue(t *testing.T) {
+	reportWg := &sync.WaitGroup{}
+	reportWg.Add(2)
+	mr := MockReporter{
+		WaitGroup: reportWg,
+	}
+	tracer, _ := NewTracer("service", WithReporter(&mr))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("root"))
+	if err != nil {
+		t.Error(err)
+	}
+	snapshot := Capture(ctx)
+	if snapshot == nil {
+		t.Fatal("snapshot should not be nil")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		workerSpan, _, err := tracer.Continue(context.Background(), snapshot, WithOperationName("worker"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		workerSpan.End()
+	}()
+	<-done
+	span.End()
+	reportWg.Wait()
+	if err := mr.Verify(1, 1); err != nil {
+		t.Error(err)
+	}
+	var root, worker ReportedSpan
+	for _, segment := range mr.Message {
+		if segment[0].OperationName() == "root" {
+			root = segment[0]
+		} else {
+			worker = segment[0]
+		}
+	}
+	if root == nil || worker == nil {
+		t.Fatal("segments are not reported")
+	}
+	ref := worker.Context().CrossThreadRef
+	if ref == nil {
+		t.Fatal("cross thread reference is not set")
+	}
+	if ref.SegmentID != root.Context().SegmentID || ref.SpanID != root.Context().SpanID {
+		t.Error("cross thread reference is wrong")
+	}
+	if ref.ParentEndpoint != "root" {
+		t.Error("parent endpoint is wrong")
+	}
+	if worker.Context().TraceID != root.Context().TraceID {
+		t.Error("trace id is different")
+	}
+	if root.Context().CrossThreadRef != nil {
+		t.Error("root segment should not have cross thread reference")
+	}
+}
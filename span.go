@@ -19,6 +19,7 @@ package go2sky
 
 import (
 	"math"
+	"sync"
 	"time"
 
 	"github.com/SkyAPM/go2sky/internal/tool"
@@ -52,6 +53,12 @@ type Span interface {
 	End()
 	IsEntry() bool
 	IsExit() bool
+	// PrepareAsync switches the span to async mode. An async span is not
+	// finished by End, it can be changed by other goroutines and must be
+	// finished by AsyncFinish.
+	PrepareAsync()
+	// AsyncFinish finishes a span prepared by PrepareAsync.
+	AsyncFinish()
 }
 
 func newLocalSpan(t *Tracer) *defaultSpan {
@@ -75,34 +82,62 @@ type defaultSpan struct {
 	Logs          []*v3.Log
 	IsError       bool
 	SpanType      SpanType
+	snapshot      *ContextSnapshot
+	skipAnalysis  bool
+	traceID       string
+	forceSample   bool
+	unsampled     bool
+
+	// mu guards the span which is changed by other goroutines in async mode
+	mu       sync.Mutex
+	async    bool
+	finished bool
 }
 
 // For Span
 func (ds *defaultSpan) SetOperationName(name string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.OperationName = name
 }
 
 func (ds *defaultSpan) GetOperationName() string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	return ds.OperationName
 }
 
 func (ds *defaultSpan) SetPeer(peer string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.Peer = peer
 }
 
 func (ds *defaultSpan) SetSpanLayer(layer v3.SpanLayer) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.Layer = layer
 }
 
 func (ds *defaultSpan) SetComponent(componentID int32) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.ComponentID = componentID
 }
 
 func (ds *defaultSpan) Tag(key Tag, value string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.Tags = append(ds.Tags, &common.KeyStringValuePair{Key: string(key), Value: value})
 }
 
 func (ds *defaultSpan) Log(time time.Time, ll ...string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.log(time, ll...)
+}
+
+func (ds *defaultSpan) log(time time.Time, ll ...string) {
 	data := make([]*common.KeyStringValuePair, 0, int32(math.Ceil(float64(len(ll))/2.0)))
 	var kvp *common.KeyStringValuePair
 	for i, l := range ll {
@@ -120,12 +155,68 @@ func (ds *defaultSpan) Log(time time.Time, ll ...string) {
 }
 
 func (ds *defaultSpan) Error(time time.Time, ll ...string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	ds.IsError = true
-	ds.Log(time, ll...)
+	ds.log(time, ll...)
 }
 
 func (ds *defaultSpan) End() {
+	ds.end()
+}
+
+func (ds *defaultSpan) PrepareAsync() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if !ds.finished {
+		ds.async = true
+	}
+}
+
+func (ds *defaultSpan) AsyncFinish() {
+	ds.asyncFinish()
+}
+
+// end sets the end time of a sync span, returns true when the span is finished
+// by this invocation.
+func (ds *defaultSpan) end() bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.finished || ds.async {
+		return false
+	}
 	ds.EndTime = time.Now()
+	ds.finished = true
+	return true
+}
+
+// asyncFinish sets the end time of an async span, returns true when the span
+// is finished by this invocation.
+func (ds *defaultSpan) asyncFinish() bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.finished || !ds.async {
+		return false
+	}
+	ds.EndTime = time.Now()
+	ds.finished = true
+	return true
+}
+
+// addDefaultTags adds the tags whose keys are absent from the span
+func (ds *defaultSpan) addDefaultTags(tags []*common.KeyStringValuePair) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	n := len(ds.Tags)
+Tags:
+	for _, tag := range tags {
+		for _, t := range ds.Tags[:n] {
+			if t.Key == tag.Key {
+				continue Tags
+			}
+		}
+		ds.Tags = append(ds.Tags, &common.KeyStringValuePair{Key: tag.Key, Value: tag.Value})
+	}
 }
 
 func (ds *defaultSpan) IsEntry() bool {
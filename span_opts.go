@@ -17,7 +17,10 @@
 
 package go2sky
 
-import "github.com/SkyAPM/go2sky/propagation"
+import (
+	"github.com/SkyAPM/go2sky/propagation"
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+)
 
 // WithContext setup trace sc from propagation
 func WithContext(sc *propagation.SpanContext) SpanOption {
@@ -42,3 +45,32 @@ func WithOperationName(operationName string) SpanOption {
 		s.OperationName = operationName
 	}
 }
+
+// WithTag setup the initial tag of a span, which is visible to Sampler
+func WithTag(key Tag, value string) SpanOption {
+	return func(s *defaultSpan) {
+		s.Tags = append(s.Tags, &common.KeyStringValuePair{Key: string(key), Value: value})
+	}
+}
+
+// WithForceSample samples the new trace or segment from upstream without asking the Sampler,
+// the sampling decision of upstream is overridden.
+func WithForceSample() SpanOption {
+	return func(s *defaultSpan) {
+		s.forceSample = true
+	}
+}
+
+// WithSkipAnalysis marks the trace is not analyzed by OAP server, eg: synthetic probes.
+// It takes effect on the first span of a segment, and propagates to downstream by sw8-x header.
+func WithSkipAnalysis() SpanOption {
+	return func(s *defaultSpan) {
+		s.skipAnalysis = true
+	}
+}
+
+func withSnapshot(snapshot *ContextSnapshot) SpanOption {
+	return func(s *defaultSpan) {
+		s.snapshot = snapshot
+	}
+}
@@ -0,0 +1,83 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+// SpanProcessor hooks the spans created by Tracer, eg: to add standard tags,
+// drop noisy spans or mirror the data elsewhere.
+type SpanProcessor interface {
+	// OnStart is invoked in the goroutine creating the span, after the span is created.
+	OnStart(span Span)
+	// OnEnd is invoked in the segment assembly goroutine with each span of a finished
+	// segment before it is sent to reporter. Returns false to drop the span and its
+	// descendants, the whole segment is dropped with its first span.
+	OnEnd(span ReportedSpan) bool
+}
+
+// WithSpanProcessor adds processors of the spans, which are invoked in order
+func WithSpanProcessor(processors ...SpanProcessor) TracerOption {
+	return func(t *Tracer) {
+		t.processors = append(t.processors, processors...)
+	}
+}
+
+func (t *Tracer) onStart(span Span) {
+	for _, p := range t.processors {
+		p.OnStart(span)
+	}
+}
+
+// onEnd returns the spans of the segment kept by all processors,
+// the root span is still the last one.
+func (t *Tracer) onEnd(spans []ReportedSpan) []ReportedSpan {
+	if len(t.processors) == 0 {
+		return spans
+	}
+	var dropped map[int32]bool
+	parents := make(map[int32]int32, len(spans))
+	for _, span := range spans {
+		parents[span.Context().SpanID] = span.Context().ParentSpanID
+		for _, p := range t.processors {
+			if !p.OnEnd(span) {
+				if dropped == nil {
+					dropped = make(map[int32]bool)
+				}
+				dropped[span.Context().SpanID] = true
+				break
+			}
+		}
+	}
+	if dropped == nil {
+		return spans
+	}
+	kept := make([]ReportedSpan, 0, len(spans))
+	for _, span := range spans {
+		if !droppedWithAncestor(span.Context().SpanID, parents, dropped) {
+			kept = append(kept, span)
+		}
+	}
+	return kept
+}
+
+func droppedWithAncestor(spanID int32, parents map[int32]int32, dropped map[int32]bool) bool {
+	for id, ok := spanID, true; ok; id, ok = parents[id] {
+		if dropped[id] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Licensed to SkyAPM org under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. SkyAPM org licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package go2sky
+
+import (
+	"context"
+	"testing"
+)
+
+type mockSpanProcessor struct {
+	ended []string
+}
+
+func (p *mockSpanProcessor) OnStart(span Span) {
+	span.Tag("region", "us-east-1")
+}
+
+func (p *mockSpanProcessor) OnEnd(span ReportedSpan) bool {
+	p.ended = append(p.ended, span.OperationName())
+	return span.OperationName() != "noisy"
+}
+
+func TestTracer_SpanProcessor(t *testing.T) {
+	reporter := &mockRegisterReporter{}
+	processor := &mockSpanProcessor{}
+	tracer, _ := NewTracer("service", WithReporter(reporter), WithSpanProcessor(processor))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background(), WithOperationName("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noisy, noisyCtx, err := tracer.CreateLocalSpan(ctx, WithOperationName("noisy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _, err := tracer.CreateLocalSpan(noisyCtx, WithOperationName("child"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sibling, _, err := tracer.CreateLocalSpan(ctx, WithOperationName("sibling"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.End()
+	noisy.End()
+	sibling.End()
+	span.End()
+	reporter.wait()
+	if len(processor.ended) != 4 {
+		t.Errorf("OnEnd invoked with %v", processor.ended)
+	}
+	if len(reporter.Spans) != 2 {
+		t.Fatalf("noisy span and its child should be dropped, got %d spans", len(reporter.Spans))
+	}
+	for _, s := range reporter.Spans {
+		if len(s.Tags()) != 1 || s.Tags()[0].Value != "us-east-1" {
+			t.Errorf("span %s is not tagged by OnStart", s.OperationName())
+		}
+	}
+	if reporter.Spans[1].OperationName() != "root" {
+		t.Error("root span should be the last one")
+	}
+
+	reporter = &mockRegisterReporter{}
+	tracer, _ = NewTracer("service", WithReporter(reporter), WithSpanProcessor(processor))
+	span, _, err = tracer.CreateLocalSpan(context.Background(), WithOperationName("noisy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+	if err = tracer.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if reporter.Spans != nil {
+		t.Error("segment should be dropped with its first span")
+	}
+}
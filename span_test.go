@@ -292,3 +292,20 @@ func Test_defaultSpan_SpanType(t *testing.T) {
 		})
 	}
 }
+
+func Test_defaultSpan_AsyncFinish(t *testing.T) {
+	ds := &defaultSpan{}
+	ds.PrepareAsync()
+	if ds.end() {
+		t.Error("async span should not be finished by end")
+	}
+	if !ds.asyncFinish() {
+		t.Error("async span should be finished by async finish")
+	}
+	if ds.asyncFinish() || ds.end() {
+		t.Error("span should be finished only once")
+	}
+	if ds.EndTime.IsZero() {
+		t.Error("end time is not set")
+	}
+}
@@ -19,18 +19,36 @@ package go2sky
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/SkyAPM/go2sky/internal/idgen"
 	"github.com/pkg/errors"
 
 	"github.com/SkyAPM/go2sky/internal/tool"
 	"github.com/SkyAPM/go2sky/propagation"
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+	v3 "github.com/SkyAPM/go2sky/reporter/grpc/language-agent"
 )
 
 const (
-	errParameter = tool.Error("parameter are nil")
-	EmptyTraceID = "N/A"
-	NoopTraceID  = "[Ignored Trace]"
+	errParameter         = tool.Error("parameter are nil")
+	EmptyTraceID         = "N/A"
+	NoopTraceID          = "[Ignored Trace]"
+	EmptySegmentID       = "N/A"
+	NoopSegmentID        = "[Ignored Segment]"
+	EmptySpanID    int32 = -1
+)
+
+const (
+	tracerRunning int32 = iota
+	tracerClosing
+	tracerClosed
+
+	closeCheckInterval = 10 * time.Millisecond
 )
 
 // Tracer is go2sky tracer implementation.
@@ -40,7 +58,24 @@ type Tracer struct {
 	reporter Reporter
 	// 0 not init 1 init
 	initFlag int32
-	sampler  Sampler
+	// 0 enabled 1 disabled
+	disabled  int32
+	sampler   Sampler
+	samplerMu sync.RWMutex
+	// nil until OAP server pushes the configuration
+	samplingConfig *samplingConfig
+	// tracerRunning, tracerClosing or tracerClosed
+	state        int32
+	openSegments int32
+	// 0 means no limit
+	spanLimitPerSegment int32
+	// unsampled segments are recorded and kept on error or slowness
+	tailRetention bool
+	slowThreshold time.Duration
+	processors    []SpanProcessor
+	// sorted by key
+	defaultTags   []*common.KeyStringValuePair
+	entrySpanTags []*common.KeyStringValuePair
 }
 
 // TracerOption allows for functional options to adjust behaviour
@@ -60,7 +95,13 @@ func NewTracer(service string, opts ...TracerOption) (tracer *Tracer, err error)
 		opt(t)
 	}
 
+	if t.sampler == nil {
+		t.sampler = NewConstSampler(true)
+	}
 	if t.reporter != nil {
+		if d, ok := t.reporter.(CommandDispatcher); ok {
+			d.RegisterCommandHandler(CommandConfigurationDiscovery, t.handleConfiguration)
+		}
 		if t.instance == "" {
 			id, err := idgen.UUID()
 			if err != nil {
@@ -71,15 +112,11 @@ func NewTracer(service string, opts ...TracerOption) (tracer *Tracer, err error)
 		t.reporter.Boot(t.service, t.instance)
 		t.initFlag = 1
 	}
-
-	if t.sampler == nil {
-		t.sampler = NewConstSampler(true)
-	}
 	return t, nil
 }
 
 // CreateEntrySpan creates and starts an entry span for incoming request
-func (t *Tracer) CreateEntrySpan(ctx context.Context, operationName string, extractor propagation.Extractor) (s Span, nCtx context.Context, err error) {
+func (t *Tracer) CreateEntrySpan(ctx context.Context, operationName string, extractor propagation.Extractor, opts ...SpanOption) (s Span, nCtx context.Context, err error) {
 	if ctx == nil || operationName == "" || extractor == nil {
 		return nil, nil, errParameter
 	}
@@ -98,7 +135,8 @@ func (t *Tracer) CreateEntrySpan(ctx context.Context, operationName string, extr
 			return
 		}
 	}
-	s, nCtx, err = t.CreateLocalSpan(ctx, WithContext(refSc), WithSpanType(SpanTypeEntry), WithOperationName(operationName))
+	opts = append([]SpanOption{WithOperationName(operationName)}, opts...)
+	s, nCtx, err = t.CreateLocalSpan(ctx, append(opts, WithContext(refSc), WithSpanType(SpanTypeEntry))...)
 	if err != nil {
 		return
 	}
@@ -113,6 +151,13 @@ func (t *Tracer) CreateLocalSpan(ctx context.Context, opts ...SpanOption) (s Spa
 	if s, c = t.createNoop(ctx); s != nil {
 		return
 	}
+	// The segment started by the span is waited by Close, the creation is counted
+	// as an open segment before the state is checked again
+	if !t.holdClose() {
+		s = &NoopSpan{}
+		return s, context.WithValue(ctx, ctxKeyInstance, s), nil
+	}
+	defer atomic.AddInt32(&t.openSegments, -1)
 	ds := newLocalSpan(t)
 	for _, opt := range opts {
 		opt(ds)
@@ -121,63 +166,282 @@ func (t *Tracer) CreateLocalSpan(ctx context.Context, opts ...SpanOption) (s Spa
 	if !ok {
 		parentSpan = nil
 	}
-	isForceSample := len(ds.Refs) > 0
-	// Try to sample when it is not force sample
-	if parentSpan == nil && !isForceSample {
-		// Force sample
-		sampled := t.sampler.IsSampled(ds.OperationName)
-		if !sampled {
+	if ds.snapshot != nil {
+		// Continue the trace in a new segment
+		parentSpan = nil
+	}
+	if parentSpan != nil && parentSpan.spanLimitExceeded(t.spanLimitPerSegment) {
+		s = &NoopSpan{limited: parentSpan}
+		return s, context.WithValue(ctx, ctxKeyInstance, s), nil
+	}
+	// The sampling decision of the captured span is honored
+	if parentSpan == nil && ds.snapshot == nil && !ds.forceSample {
+		sampled, ignored, err := t.sample(ds)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ignored || !sampled && !t.tailRetention {
 			// Filter by sample just return noop span
 			s = &NoopSpan{}
 			return s, context.WithValue(ctx, ctxKeyInstance, s), nil
 		}
+		// The unsampled segment is recorded, the decision is deferred until it ends
+		ds.unsampled = !sampled
 	}
 	s, err = newSegmentSpan(ds, parentSpan)
 	if err != nil {
 		return nil, nil, err
 	}
+	t.onStart(s)
 	return s, context.WithValue(ctx, ctxKeyInstance, s), nil
 }
 
+// sample makes the sampling decision of a new trace or a segment from upstream,
+// the segment ignored by the sampler is never recorded by the tail retention.
+func (t *Tracer) sample(ds *defaultSpan) (sampled bool, ignored bool, err error) {
+	params := SamplingParameters{
+		OperationName: ds.OperationName,
+		SpanType:      ds.SpanType,
+		Tags:          ds.Tags,
+	}
+	if len(ds.Refs) > 0 {
+		params.ParentContext = ds.Refs[0]
+		params.TraceID = ds.Refs[0].TraceID
+	}
+	t.samplerMu.RLock()
+	sampler := t.sampler
+	t.samplerMu.RUnlock()
+	// The trace id is not generated for the ignored trace
+	if is, ok := sampler.(IgnoringSampler); ok && is.IsIgnored(params) {
+		return false, true, nil
+	}
+	if params.ParentContext == nil {
+		traceID, err := idgen.GenerateGlobalID()
+		if err != nil {
+			return false, false, err
+		}
+		// The trace id is used by the segment when it is sampled
+		ds.traceID = traceID
+		params.TraceID = traceID
+	}
+	return sampler.IsSampled(params), false, nil
+}
+
+// SetSampler replaces the sampler of the tracer at runtime, nil sampler is ignored.
+// The configuration delivered by the reporter still takes effect on the new sampler.
+func (t *Tracer) SetSampler(sampler Sampler) {
+	if sampler == nil {
+		return
+	}
+	t.samplerMu.Lock()
+	defer t.samplerMu.Unlock()
+	if t.samplingConfig != nil {
+		t.samplingConfig.base = sampler
+		t.sampler = t.samplingConfig.sampler()
+		return
+	}
+	t.sampler = sampler
+}
+
+// SetEnabled turns the tracer on or off at runtime.
+// A disabled tracer creates NoopSpan, the spans created before are still reported.
+func (t *Tracer) SetEnabled(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&t.disabled, 0)
+	} else {
+		atomic.StoreInt32(&t.disabled, 1)
+	}
+}
+
+// Continue creates and starts a local span in a new segment, which refers to the
+// span captured by the snapshot. It is used to continue the trace in another goroutine.
+// Continue works as CreateLocalSpan when the snapshot is nil.
+func (t *Tracer) Continue(ctx context.Context, snapshot *ContextSnapshot, opts ...SpanOption) (s Span, c context.Context, err error) {
+	if snapshot == nil {
+		return t.CreateLocalSpan(ctx, opts...)
+	}
+	return t.CreateLocalSpan(ctx, append(opts, withSnapshot(snapshot))...)
+}
+
 // CreateExitSpan creates and starts an exit span for client
 func (t *Tracer) CreateExitSpan(ctx context.Context, operationName string, peer string, injector propagation.Injector) (Span, error) {
 	if ctx == nil || operationName == "" || peer == "" || injector == nil {
 		return nil, errParameter
 	}
 	if s, _ := t.createNoop(ctx); s != nil {
+		if err := t.injectLimited(s, peer, injector); err != nil {
+			return nil, err
+		}
 		return s, nil
 	}
 	s, _, err := t.CreateLocalSpan(ctx, WithSpanType(SpanTypeExit), WithOperationName(operationName))
 	if err != nil {
 		return nil, err
 	}
-	noopSpan, ok := interface{}(s).(NoopSpan)
-	if ok {
-		// Ignored, there is no need to inject SW8 in the request header
-		return &noopSpan, nil
+	if noopSpan, ok := s.(*NoopSpan); ok {
+		// Ignored, there is no need to inject SW8 in the request header,
+		// unless the segment is truncated by the span limit
+		if err = t.injectLimited(noopSpan, peer, injector); err != nil {
+			return nil, err
+		}
+		return noopSpan, nil
 	}
 	s.SetPeer(peer)
-	spanContext := &propagation.SpanContext{}
 	span, ok := s.(ReportedSpan)
 	if !ok {
 		return nil, errors.New("span type is wrong")
 	}
+	if err = t.inject(span.Context(), peer, injector); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	firstSpan := span.Context().FirstSpan
-	spanContext.Sample = 1
-	spanContext.TraceID = span.Context().TraceID
-	spanContext.ParentSegmentID = span.Context().SegmentID
-	spanContext.ParentSpanID = span.Context().SpanID
+// injectLimited injects the context of the span in the segment truncated by the
+// span limit, so the trace is still continued by downstream services.
+func (t *Tracer) injectLimited(s Span, peer string, injector propagation.Injector) error {
+	noopSpan, ok := s.(*NoopSpan)
+	if !ok || noopSpan.limited == nil {
+		return nil
+	}
+	c := noopSpan.limited.context()
+	return t.inject(&c, peer, injector)
+}
+
+// inject injects SW8 of the span in the segment into the request header
+func (t *Tracer) inject(c *SegmentContext, peer string, injector propagation.Injector) error {
+	spanContext := &propagation.SpanContext{}
+	if c.sampled {
+		spanContext.Sample = 1
+	}
+	spanContext.TraceID = c.TraceID
+	spanContext.ParentSegmentID = c.SegmentID
+	spanContext.ParentSpanID = c.SpanID
 	spanContext.ParentService = t.service
 	spanContext.ParentServiceInstance = t.instance
-	spanContext.ParentEndpoint = firstSpan.GetOperationName()
+	spanContext.ParentEndpoint = c.FirstSpan.GetOperationName()
 	spanContext.AddressUsedAtClient = peer
+	return injector(spanContext.EncodeSW8())
+}
 
-	err = injector(spanContext.EncodeSW8())
-	if err != nil {
-		return nil, err
+// Close stops the tracer from creating new spans, waits for the open segments
+// to be finished until ctx is done, then flushes and closes the reporter.
+// The returned error summarizes the segments dropped during shutdown.
+func (t *Tracer) Close(ctx context.Context) error {
+	if ctx == nil {
+		return errParameter
 	}
-	return s, nil
+	if !atomic.CompareAndSwapInt32(&t.state, tracerRunning, tracerClosing) {
+		return nil
+	}
+	if t.reporter == nil {
+		atomic.StoreInt32(&t.state, tracerClosed)
+		return nil
+	}
+	var dropped []string
+	if n := t.waitSegments(ctx); n > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d segments unfinished", n))
+	}
+	if fr, ok := t.reporter.(FlushReporter); ok {
+		if err := fr.Flush(ctx); err != nil {
+			dropped = append(dropped, err.Error())
+		}
+	}
+	atomic.StoreInt32(&t.state, tracerClosed)
+	t.reporter.Close()
+	if len(dropped) > 0 {
+		return errors.Errorf("tracer closed with data loss: %s", strings.Join(dropped, ", "))
+	}
+	return nil
+}
+
+// waitSegments waits for all open segments to be reported,
+// returns the number of segments still open when ctx is done.
+func (t *Tracer) waitSegments(ctx context.Context) int32 {
+	ticker := time.NewTicker(closeCheckInterval)
+	defer ticker.Stop()
+	for {
+		n := atomic.LoadInt32(&t.openSegments)
+		if n <= 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tracer) segmentOpened() {
+	atomic.AddInt32(&t.openSegments, 1)
+}
+
+// holdClose counts an open segment and returns true when the tracer is running,
+// so Close does not miss the segment created concurrently.
+func (t *Tracer) holdClose() bool {
+	atomic.AddInt32(&t.openSegments, 1)
+	if atomic.LoadInt32(&t.state) != tracerRunning {
+		atomic.AddInt32(&t.openSegments, -1)
+		return false
+	}
+	return true
+}
+
+// reportSegment sends the finished segment to reporter, the segment is dropped
+// when the tracer is closed, it is not sampled and not retained, or its first
+// span is dropped by the span processors.
+func (t *Tracer) reportSegment(spans []ReportedSpan) {
+	defer atomic.AddInt32(&t.openSegments, -1)
+	if atomic.LoadInt32(&t.state) == tracerClosed {
+		return
+	}
+	if !spans[len(spans)-1].Context().sampled && !t.retain(spans) {
+		return
+	}
+	t.addDefaultTags(spans)
+	if spans = t.onEnd(spans); len(spans) == 0 {
+		return
+	}
+	t.reporter.Send(spans)
+}
+
+// addDefaultTags adds the default tags to the finished spans, the tags set on
+// the span before it is reported take precedence.
+func (t *Tracer) addDefaultTags(spans []ReportedSpan) {
+	if len(t.defaultTags) == 0 && len(t.entrySpanTags) == 0 {
+		return
+	}
+	for _, span := range spans {
+		s, ok := span.(interface {
+			addDefaultTags(tags []*common.KeyStringValuePair)
+		})
+		if !ok {
+			continue
+		}
+		if span.SpanType() == v3.SpanType_Entry {
+			s.addDefaultTags(t.entrySpanTags)
+		}
+		s.addDefaultTags(t.defaultTags)
+	}
+}
+
+// retain returns true when the tail retention is enabled and the segment
+// has an error span or its root span is slower than the threshold.
+func (t *Tracer) retain(spans []ReportedSpan) bool {
+	if !t.tailRetention {
+		return false
+	}
+	for _, span := range spans {
+		if span.IsError() {
+			return true
+		}
+	}
+	if t.slowThreshold <= 0 {
+		return false
+	}
+	root := spans[len(spans)-1]
+	return time.Duration(root.EndTime()-root.StartTime())*time.Millisecond > t.slowThreshold
 }
 
 func (t *Tracer) createNoop(ctx context.Context) (s Span, nCtx context.Context) {
@@ -186,7 +450,7 @@ func (t *Tracer) createNoop(ctx context.Context) (s Span, nCtx context.Context)
 		s = ns
 		return
 	}
-	if t.initFlag == 0 {
+	if t.initFlag == 0 || atomic.LoadInt32(&t.disabled) == 1 || atomic.LoadInt32(&t.state) != tracerRunning {
 		s = &NoopSpan{}
 		nCtx = context.WithValue(ctx, ctxKeyInstance, s)
 		return
@@ -205,6 +469,14 @@ type Reporter interface {
 	Close()
 }
 
+// FlushReporter is a Reporter which buffers segments. Flush stops accepting
+// segments and waits for the buffered ones to be sent until ctx is done.
+// Tracer.Close flushes the reporter before closing it.
+type FlushReporter interface {
+	Reporter
+	Flush(ctx context.Context) error
+}
+
 func TraceID(ctx context.Context) string {
 	activeSpan := ctx.Value(ctxKeyInstance)
 	if activeSpan == nil {
@@ -216,3 +488,55 @@ func TraceID(ctx context.Context) string {
 	}
 	return NoopTraceID
 }
+
+// SegmentID returns the segment id of the active span in ctx,
+// EmptySegmentID without active span and NoopSegmentID for NoopSpan.
+func SegmentID(ctx context.Context) string {
+	activeSpan := ctx.Value(ctxKeyInstance)
+	if activeSpan == nil {
+		return EmptySegmentID
+	}
+	span, ok := activeSpan.(segmentSpan)
+	if ok {
+		return span.context().SegmentID
+	}
+	return NoopSegmentID
+}
+
+// SpanID returns the span id of the active span in ctx,
+// EmptySpanID without active span or for NoopSpan.
+func SpanID(ctx context.Context) int32 {
+	span, ok := ctx.Value(ctxKeyInstance).(segmentSpan)
+	if ok {
+		return span.context().SpanID
+	}
+	return EmptySpanID
+}
+
+// ActiveSpan returns the active span in ctx, which is nil without active span.
+// The span is a NoopSpan when the trace is ignored.
+func ActiveSpan(ctx context.Context) Span {
+	span, ok := ctx.Value(ctxKeyInstance).(Span)
+	if !ok {
+		return nil
+	}
+	return span
+}
+
+// IsSampled returns true when the active span in ctx is going to be reported.
+func IsSampled(ctx context.Context) bool {
+	span, ok := ctx.Value(ctxKeyInstance).(segmentSpan)
+	return ok && span.context().sampled
+}
+
+// IsSkipAnalysis returns true when the trace of the active span in ctx is not analyzed by OAP server.
+func IsSkipAnalysis(ctx context.Context) bool {
+	span, ok := ctx.Value(ctxKeyInstance).(segmentSpan)
+	return ok && span.context().SkipAnalysis
+}
+
+// ContextWithSpan returns a copy of ctx in which span is the active span.
+// The spans created with the returned context are the children of span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, ctxKeyInstance, span)
+}
@@ -17,6 +17,13 @@
 
 package go2sky
 
+import (
+	"sort"
+	"time"
+
+	"github.com/SkyAPM/go2sky/reporter/grpc/common"
+)
+
 // WithReporter setup report pipeline for tracer
 func WithReporter(reporter Reporter) TracerOption {
 	return func(t *Tracer) {
@@ -46,3 +53,61 @@ func WithSampler(samplingRate float64) TracerOption {
 		t.sampler = sampler
 	}
 }
+
+// WithSamplerInstance setup a custom sampler
+func WithSamplerInstance(sampler Sampler) TracerOption {
+	return func(t *Tracer) {
+		t.sampler = sampler
+	}
+}
+
+// WithTailRetention records the segments not sampled by the sampler or upstream
+// instead of ignoring them. A finished segment is reported when any of its spans
+// is error or its root span takes longer than slowThreshold, and discarded otherwise.
+// slowThreshold <= 0 retains error segments only.
+func WithTailRetention(slowThreshold time.Duration) TracerOption {
+	return func(t *Tracer) {
+		t.tailRetention = true
+		t.slowThreshold = slowThreshold
+	}
+}
+
+// WithDefaultTags setup the tags of all spans created by tracer, eg: version, cluster.
+// They are added when the segment is reported, the tags set on the span take precedence.
+func WithDefaultTags(tags map[string]string) TracerOption {
+	return func(t *Tracer) {
+		t.defaultTags = mergeTags(t.defaultTags, tags)
+	}
+}
+
+// WithEntrySpanTags setup the tags of entry spans created by tracer,
+// which take precedence over the default tags.
+func WithEntrySpanTags(tags map[string]string) TracerOption {
+	return func(t *Tracer) {
+		t.entrySpanTags = mergeTags(t.entrySpanTags, tags)
+	}
+}
+
+// mergeTags puts the tags into the sorted tag list
+func mergeTags(list []*common.KeyStringValuePair, tags map[string]string) []*common.KeyStringValuePair {
+	for k, v := range tags {
+		i := sort.Search(len(list), func(i int) bool { return list[i].Key >= k })
+		if i < len(list) && list[i].Key == k {
+			list[i].Value = v
+			continue
+		}
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = &common.KeyStringValuePair{Key: k, Value: v}
+	}
+	return list
+}
+
+// WithSpanLimitPerSegment setup the max number of spans in a segment,
+// the spans exceeding the limit are ignored and the segment is reported as size limited.
+// The exit spans exceeding the limit still inject the context of the segment.
+func WithSpanLimitPerSegment(limit int) TracerOption {
+	return func(t *Tracer) {
+		t.spanLimitPerSegment = int32(limit)
+	}
+}
@@ -163,6 +163,91 @@ func TestTracer_EntryAndExitInTrace(t *testing.T) {
 	wg.Wait()
 }
 
+func TestTracer_SkipAnalysis(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	reporter := &NoopReporter{wg: wg}
+	tracer, err := NewTracer("service", WithReporter(reporter))
+	if err != nil {
+		t.Error(err)
+	}
+	entrySpan, ctx, err := tracer.CreateEntrySpan(context.Background(), "/rest/api", func() (string, error) {
+		return header, nil
+	}, WithSkipAnalysis())
+	if err != nil {
+		t.Error(err)
+	}
+	if !IsSkipAnalysis(ctx) {
+		t.Error("trace should skip analysis")
+	}
+	exitSpan, err := tracer.CreateExitSpan(ctx, "/foo/bar", "foo.svc:8787", func(head string) error {
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !Capture(ctx).SkipAnalysis {
+		t.Error("snapshot should skip analysis")
+	}
+	exitSpan.End()
+	entrySpan.End()
+	wg.Wait()
+	for _, s := range reporter.Spans {
+		if !s.Context().SkipAnalysis {
+			t.Errorf("span %s should skip analysis", s.OperationName())
+		}
+	}
+}
+
+func TestTracer_EntryNotSampled(t *testing.T) {
+	reporter := &NoopReporter{wg: &sync.WaitGroup{}}
+	tracer, err := NewTracer("service", WithReporter(reporter))
+	if err != nil {
+		t.Error(err)
+	}
+	scx := propagation.SpanContext{}
+	if err = scx.DecodeSW8(header); err != nil {
+		t.Fatal(err)
+	}
+	scx.Sample = 0
+	entrySpan, ctx, err := tracer.CreateEntrySpan(context.Background(), "/rest/api", func() (string, error) {
+		return scx.EncodeSW8(), nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if IsSampled(ctx) {
+		t.Error("span should not be sampled")
+	}
+	if TraceID(ctx) != traceID {
+		t.Error("trace id should be propagated")
+	}
+	exitSpan, err := tracer.CreateExitSpan(ctx, "/foo/bar", "foo.svc:8787", func(head string) error {
+		sc := propagation.SpanContext{}
+		if err := sc.DecodeSW8(head); err != nil {
+			t.Error(err)
+		}
+		if sc.Sample != 0 {
+			t.Error("sampling decision should be propagated")
+		}
+		if sc.TraceID != traceID {
+			t.Error("trace id should be propagated")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	exitSpan.End()
+	entrySpan.End()
+	if err = tracer.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if reporter.Spans != nil {
+		t.Error("segment should not be reported")
+	}
+}
+
 type NoopReporter struct {
 	wg    *sync.WaitGroup
 	Spans []ReportedSpan
@@ -21,7 +21,9 @@ import (
 	"context"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/SkyAPM/go2sky/propagation"
 )
@@ -99,6 +101,241 @@ func verifySpans(t *testing.T, span ReportedSpan, subSpan ReportedSpan) {
 	}
 }
 
+func TestTracer_Close(t *testing.T) {
+	reporter := &mockRegisterReporter{
+		success: true,
+	}
+	tracer, _ := NewTracer("service", WithReporter(reporter))
+	span, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		span.End()
+	}()
+	if err := tracer.Close(context.Background()); err != nil {
+		t.Errorf("close tracer error %v", err)
+	}
+	reporter.wait()
+	if len(reporter.Spans) != 1 {
+		t.Errorf("segment is not reported before close")
+	}
+	s, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := s.(*NoopSpan); !ok {
+		t.Error("closed tracer should create noop span")
+	}
+}
+
+type countingReporter struct {
+	mockRegisterReporter
+	segments int32
+}
+
+func (r *countingReporter) Send(spans []ReportedSpan) {
+	atomic.AddInt32(&r.segments, 1)
+}
+
+func TestTracer_CloseConcurrently(t *testing.T) {
+	reporter := &countingReporter{}
+	tracer, _ := NewTracer("service", WithReporter(reporter))
+	var created int32
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			// Create spans until the tracer is closed
+			for {
+				span, _, err := tracer.CreateLocalSpan(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, ok := span.(*NoopSpan); ok {
+					return
+				}
+				atomic.AddInt32(&created, 1)
+				span.End()
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := tracer.Close(context.Background()); err != nil {
+		t.Errorf("close tracer error %v", err)
+	}
+	wg.Wait()
+	if created != atomic.LoadInt32(&reporter.segments) {
+		t.Errorf("%d segments are created, but %d reported", created, reporter.segments)
+	}
+}
+
+func TestTracer_CloseTimeout(t *testing.T) {
+	reporter := &mockRegisterReporter{
+		success: true,
+	}
+	tracer, _ := NewTracer("service", WithReporter(reporter))
+	span, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tracer.Close(ctx); err == nil {
+		t.Error("unfinished segment should be reported as dropped")
+	}
+	span.End()
+	time.Sleep(50 * time.Millisecond)
+	if reporter.Spans != nil {
+		t.Error("segment should not be reported after close")
+	}
+}
+
+func TestTracer_SetEnabled(t *testing.T) {
+	reporter := &mockRegisterReporter{
+		success: true,
+	}
+	tracer, _ := NewTracer("service", WithReporter(reporter))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	tracer.SetEnabled(false)
+	disabledSpan, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := disabledSpan.(*NoopSpan); !ok {
+		t.Error("disabled tracer should create noop span")
+	}
+	tracer.SetEnabled(true)
+	subSpan, _, err := tracer.CreateLocalSpan(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := subSpan.(*NoopSpan); ok {
+		t.Error("enabled tracer should create span")
+	}
+	subSpan.End()
+	span.End()
+	reporter.wait()
+}
+
+func TestTracer_SetSampler(t *testing.T) {
+	tracer, _ := NewTracer("service", WithReporter(&mockRegisterReporter{}))
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _, _ = tracer.CreateLocalSpan(context.Background())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tracer.SetSampler(NewConstSampler(i%2 == 0))
+		}
+	}()
+	wg.Wait()
+	tracer.SetSampler(NewConstSampler(false))
+	tracer.SetSampler(nil)
+	span, _, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := span.(*NoopSpan); !ok {
+		t.Error("span should not be sampled by the new sampler")
+	}
+}
+
+func TestTracer_TailRetention(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(span Span)
+		kept   bool
+	}{
+		{"normal", func(span Span) { span.End() }, false},
+		{"error", func(span Span) {
+			span.Error(time.Now(), "failed")
+			span.End()
+		}, true},
+		{"slow", func(span Span) {
+			time.Sleep(60 * time.Millisecond)
+			span.End()
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &mockRegisterReporter{}
+			tracer, _ := NewTracer("service", WithReporter(reporter), WithSampler(0),
+				WithTailRetention(50*time.Millisecond))
+			span, ctx, err := tracer.CreateLocalSpan(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := span.(*NoopSpan); ok {
+				t.Fatal("unsampled span should be recorded")
+			}
+			if IsSampled(ctx) {
+				t.Error("recorded span should not be sampled")
+			}
+			subSpan, _, err := tracer.CreateLocalSpan(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.finish(subSpan)
+			span.End()
+			if err := tracer.Close(context.Background()); err != nil {
+				t.Error(err)
+			}
+			if kept := len(reporter.Spans) == 2; kept != tt.kept {
+				t.Errorf("segment kept %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestTracer_DefaultTags(t *testing.T) {
+	reporter := &mockRegisterReporter{}
+	tracer, _ := NewTracer("service", WithReporter(reporter),
+		WithDefaultTags(map[string]string{"version": "v1", "cluster": "c1"}),
+		WithDefaultTags(map[string]string{"version": "v2"}),
+		WithEntrySpanTags(map[string]string{"canary": "true", "cluster": "c2"}))
+	entry, ctx, err := tracer.CreateEntrySpan(context.Background(), "entry", func() (string, error) {
+		return "", nil
+	}, WithTag("version", "v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, _, err := tracer.CreateLocalSpan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.End()
+	entry.End()
+	reporter.wait()
+	tags := func(span ReportedSpan) map[string]string {
+		m := make(map[string]string)
+		for _, tag := range span.Tags() {
+			if _, ok := m[tag.Key]; ok {
+				t.Errorf("duplicated tag %s", tag.Key)
+			}
+			m[tag.Key] = tag.Value
+		}
+		return m
+	}
+	if got, want := tags(reporter.Spans[1]), map[string]string{"version": "v3", "cluster": "c2", "canary": "true"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entry span tags %v, want %v", got, want)
+	}
+	if got, want := tags(reporter.Spans[0]), map[string]string{"version": "v2", "cluster": "c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("local span tags %v, want %v", got, want)
+	}
+}
+
 func TestTrace_TraceID(t *testing.T) {
 	// activeSpan == nil
 	traceID := TraceID(context.Background())
@@ -126,6 +363,55 @@ func TestTrace_TraceID(t *testing.T) {
 	verifyTraceID(t, span.(segmentSpan).context().TraceID, traceID)
 }
 
+func TestActiveSpan(t *testing.T) {
+	ctx := context.Background()
+	if ActiveSpan(ctx) != nil || IsSampled(ctx) {
+		t.Error("there should be no active span")
+	}
+	if SegmentID(ctx) != EmptySegmentID || SpanID(ctx) != EmptySpanID {
+		t.Error("ids without active span are wrong")
+	}
+
+	tracer, _ := NewTracer("service")
+	noop, ctx, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if ActiveSpan(ctx) != noop || IsSampled(ctx) {
+		t.Error("active span should be noop span")
+	}
+	if SegmentID(ctx) != NoopSegmentID || SpanID(ctx) != EmptySpanID {
+		t.Error("ids of noop span are wrong")
+	}
+
+	reporter := &mockRegisterReporter{
+		success: true,
+	}
+	tracer, _ = NewTracer("service", WithReporter(reporter))
+	span, ctx, err := tracer.CreateLocalSpan(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	subSpan, subCtx, err := tracer.CreateLocalSpan(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if ActiveSpan(subCtx) != subSpan || !IsSampled(subCtx) {
+		t.Error("active span should be the sub span")
+	}
+	sc := subSpan.(segmentSpan).context()
+	if SegmentID(subCtx) != sc.SegmentID || SpanID(subCtx) != sc.SpanID {
+		t.Error("ids of active span are wrong")
+	}
+	rebound := ContextWithSpan(subCtx, span)
+	if ActiveSpan(rebound) != span || SpanID(rebound) != span.(segmentSpan).context().SpanID {
+		t.Error("active span should be replaced")
+	}
+	subSpan.End()
+	span.End()
+	reporter.wait()
+}
+
 func verifyTraceID(t *testing.T, expectTraceID string, actualTraceID string) {
 	if expectTraceID != actualTraceID {
 		t.Errorf("expectTraceID: %v, actualTraceID: %v", expectTraceID, actualTraceID)
@@ -156,6 +442,7 @@ func (r *mockRegisterReporter) wait() {
 }
 
 func TestNewTracer(t *testing.T) {
+	rateLimitingSampler := NewRateLimitingSampler(10)
 	type args struct {
 		service string
 		opts    []TracerOption
@@ -184,6 +471,15 @@ func TestNewTracer(t *testing.T) {
 			&Tracer{service: "test", sampler: NewConstSampler(true)},
 			false,
 		},
+		{
+			"with sampler instance",
+			struct {
+				service string
+				opts    []TracerOption
+			}{service: "test", opts: []TracerOption{WithSamplerInstance(rateLimitingSampler)}},
+			&Tracer{service: "test", sampler: rateLimitingSampler},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {